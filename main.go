@@ -1,17 +1,25 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"sob/pkg/digest"
+	"sob/pkg/events"
 	"sob/pkg/handlers"
+	"sob/pkg/mailer"
 	"sob/pkg/middleware"
 	"sob/pkg/models"
+	"sob/pkg/notify"
+	"sob/pkg/publish"
 	"sob/pkg/session"
 	"sob/pkg/utils"
 
@@ -39,6 +47,51 @@ func main() {
 	// Инициализация репозиториев
 	userRepo := models.NewUserRepo(db)
 	bookRepo := models.NewBookRepo(db)
+	tagRepo := models.NewTagRepo(db)
+	savedSearchRepo := models.NewSavedSearchRepo(db)
+	commentRepo := models.NewCommentRepo(db)
+	shelfRepo := models.NewShelfRepo(db)
+	followRepo := models.NewFollowRepo(db)
+
+	// Шина событий: на нее подписываются доставщики уведомлений
+	eventBus := events.NewBus(func(recovered interface{}) {
+		sugar.Error("Event listener panic:", recovered)
+	})
+	eventBus.Subscribe(func(event events.Event) {
+		sugar.Infow("Event",
+			"type", event.Type,
+			"actor_id", event.ActorID,
+			"book_id", event.BookID,
+		)
+	})
+
+	// Уведомления на сайте
+	notificationRepo := models.NewNotificationRepo(db)
+	notifier := notify.NewService(notificationRepo, followRepo, sugar)
+	eventBus.Subscribe(notifier.HandleEvent)
+
+	// Личные сообщения и блокировки
+	messageRepo := models.NewMessageRepo(db)
+	blockRepo := models.NewBlockRepo(db)
+	seriesRepo := models.NewSeriesRepo(db)
+	coAuthorRepo := models.NewCoAuthorRepo(db)
+	revisionRepo := models.NewRevisionRepo(db)
+
+	// Первых модераторов назначаем из MODERATORS (имена через запятую);
+	// дальше модераторы назначают друг друга на странице /tags
+	for _, username := range strings.Split(os.Getenv("MODERATORS"), ",") {
+		if username = strings.TrimSpace(username); username == "" {
+			continue
+		}
+		if err := userRepo.SetRole(username, models.RoleModerator); err != nil {
+			sugar.Error("Failed to grant moderator role to ", username, ": ", err)
+		}
+	}
+
+	// Индексируем теги книг, загруженных до появления таблицы тегов
+	if err := tagRepo.ReindexBooks(); err != nil {
+		sugar.Error("Failed to reindex book tags:", err)
+	}
 
 	// Создаем директории если не существуют
 	os.MkdirAll("static/uploads", 0755)
@@ -57,6 +110,7 @@ func main() {
 		"split": strings.Split,
 		"join":  strings.Join,
 		"formatFileSize": utils.FormatFileSize,
+		"formatNumber":   utils.FormatNumber,
 		"without": func(tags []string, exclude string) []string {
 			var result []string
 			for _, tag := range tags {
@@ -76,6 +130,23 @@ func main() {
 		"safeHTML": func(s string) template.HTML {
 			return template.HTML(s)
 		},
+		"commentHTML": utils.RenderCommentMarkdown,
+		// Счетчик непрочитанных уведомлений для колокольчика в навигации
+		"unreadNotifications": func(userID int) int {
+			count, err := notificationRepo.CountUnread(userID)
+			if err != nil {
+				sugar.Error("Count unread notifications error:", err)
+			}
+			return count
+		},
+		// Счетчик непрочитанных личных сообщений
+		"unreadMessages": func(userID int) int {
+			count, err := messageRepo.CountUnread(userID)
+			if err != nil {
+				sugar.Error("Count unread messages error:", err)
+			}
+			return count
+		},
 		
 	}
 
@@ -87,13 +158,77 @@ func main() {
 
 	// Инициализация обработчиков
 	handler := &handlers.Handler{
-		Tmpl:      tmpl,
-		Logger:    sugar,
-		UserRepo:  userRepo,
-		BookRepo:  bookRepo,
-		Sessions:  sessionsManager,
-		UploadDir: "static/uploads",
+		Tmpl:             tmpl,
+		Logger:           sugar,
+		UserRepo:         userRepo,
+		BookRepo:         bookRepo,
+		TagRepo:          tagRepo,
+		SavedSearchRepo:  savedSearchRepo,
+		CommentRepo:      commentRepo,
+		ShelfRepo:        shelfRepo,
+		FollowRepo:       followRepo,
+		Events:           eventBus,
+		NotificationRepo: notificationRepo,
+		Notifier:         notifier,
+		MessageRepo:      messageRepo,
+		BlockRepo:        blockRepo,
+		SeriesRepo:       seriesRepo,
+		CoAuthorRepo:     coAuthorRepo,
+		RevisionRepo:     revisionRepo,
+		Sessions:         sessionsManager,
+		UploadDir:        "static/uploads",
+		Secret:           loadSecret(sugar),
+	}
+
+	// Статистика текста для книг, загруженных до ее появления
+	go handler.BackfillTextStats()
+
+	// Рассылка уведомлений на почту
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
 	}
+	digestInterval := time.Minute
+	if value := os.Getenv("DIGEST_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			digestInterval = d
+		}
+	}
+	digestScheduler := &digest.Scheduler{
+		Notifications: notificationRepo,
+		Notifier:      notifier,
+		SavedSearches: savedSearchRepo,
+		Books:         bookRepo,
+		Users:         userRepo,
+		Mailer:        mailer.FromEnv(sugar),
+		Logger:        sugar,
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		Secret:        handler.Secret,
+		Interval:      digestInterval,
+	}
+	if err := digestScheduler.LoadTemplates("templates/email"); err != nil {
+		sugar.Fatal("Failed to load email templates:", err)
+	}
+	stopDigest := make(chan struct{})
+	defer close(stopDigest)
+	go digestScheduler.Run(stopDigest)
+
+	// Публикация отложенных работ
+	publishInterval := time.Minute
+	if value := os.Getenv("PUBLISH_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			publishInterval = d
+		}
+	}
+	publishScheduler := &publish.Scheduler{
+		Books:    bookRepo,
+		Events:   eventBus,
+		Logger:   sugar,
+		Interval: publishInterval,
+	}
+	stopPublish := make(chan struct{})
+	defer close(stopPublish)
+	go publishScheduler.Run(stopPublish)
 
 	// Создание маршрутизатора
 	router := mux.NewRouter()
@@ -104,6 +239,8 @@ func main() {
 	router.Use(middleware.Auth(sessionsManager)) // Всегда проверяем сессии
 
 	// Статические файлы
+	// Файлы работ отдаются с проверкой видимости, остальная статика - как есть
+	router.PathPrefix("/static/uploads/").HandlerFunc(handler.ServeUpload)
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	// Публичные маршруты (доступны всем)
@@ -116,7 +253,13 @@ func main() {
 	router.HandleFunc("/books/{id}/read", handler.ReadBook)
 	router.HandleFunc("/search", handler.AdvancedSearch)
 	router.HandleFunc("/books/{id}/rate", handler.RateBook).Methods("POST")
+	router.HandleFunc("/books/{id}/kudos", handler.GiveKudos).Methods("POST")
 	router.HandleFunc("/books/{id}", handler.BookDetail)
+	router.HandleFunc("/shelves/{id}", handler.ShelfPage).Methods("GET")
+	router.HandleFunc("/series/{id}", handler.SeriesPage).Methods("GET")
+	router.HandleFunc("/unsubscribe", handler.Unsubscribe).Methods("GET", "POST")
+	router.HandleFunc("/users/{username}", handler.UserProfile).Methods("GET")
+	router.HandleFunc("/api/tags/autocomplete", handler.TagAutocomplete).Methods("GET")
 
 
 
@@ -129,12 +272,92 @@ func main() {
 	protected.HandleFunc("/profile", handler.Profile)
 	protected.HandleFunc("/edit-profile", handler.EditProfilePage).Methods("GET")
 	protected.HandleFunc("/update-profile", handler.UpdateProfile).Methods("POST")
+	protected.HandleFunc("/settings/adult", handler.AllowAdultContent).Methods("POST")
 	protected.HandleFunc("/books/{id}/delete", handler.DeleteBook).Methods("POST")
 	protected.HandleFunc("/logout", handler.Logout).Methods("POST")
 	protected.HandleFunc("/books/{id}/rate", handler.RateBook).Methods("POST")
 	protected.HandleFunc("/books/{id}/edit", handler.EditBookPage).Methods("GET")
 	protected.HandleFunc("/books/{id}/update", handler.UpdateBook).Methods("POST")
 
+	// Модерация тегов
+	protected.HandleFunc("/tags", handler.TagsPage).Methods("GET")
+	protected.HandleFunc("/tags/merge", handler.MergeTags).Methods("POST")
+	protected.HandleFunc("/tags/moderators", handler.GrantModerator).Methods("POST")
+	protected.HandleFunc("/tags/moderators/{id}/revoke", handler.RevokeModerator).Methods("POST")
+	protected.HandleFunc("/tags/{id}/update", handler.UpdateTag).Methods("POST")
+	protected.HandleFunc("/tags/{id}/unmerge", handler.UnmergeTag).Methods("POST")
+	protected.HandleFunc("/tags/{id}/parents", handler.AddTagParent).Methods("POST")
+	protected.HandleFunc("/tags/{id}/parents/{parentID}/delete", handler.RemoveTagParent).Methods("POST")
+
+	// Сохраненные поиски
+	protected.HandleFunc("/saved-searches", handler.SaveSearch).Methods("POST")
+	protected.HandleFunc("/saved-searches/{id}", handler.OpenSavedSearch).Methods("GET")
+	protected.HandleFunc("/saved-searches/{id}/update", handler.UpdateSavedSearch).Methods("POST")
+	protected.HandleFunc("/saved-searches/{id}/delete", handler.DeleteSavedSearch).Methods("POST")
+
+	// Комментарии
+	protected.HandleFunc("/books/{id}/comments", handler.AddComment).Methods("POST")
+	protected.HandleFunc("/comments/{id}/update", handler.UpdateComment).Methods("POST")
+	protected.HandleFunc("/comments/{id}/delete", handler.DeleteComment).Methods("POST")
+	protected.HandleFunc("/comments/{id}/hide", handler.HideComment).Methods("POST")
+
+	// Полки
+	protected.HandleFunc("/shelves", handler.CreateShelf).Methods("POST")
+	protected.HandleFunc("/shelves/{id}/update", handler.UpdateShelf).Methods("POST")
+	protected.HandleFunc("/shelves/{id}/delete", handler.DeleteShelf).Methods("POST")
+
+	// Циклы
+	protected.HandleFunc("/series", handler.CreateSeries).Methods("POST")
+	protected.HandleFunc("/series/{id}/update", handler.UpdateSeries).Methods("POST")
+	protected.HandleFunc("/series/{id}/delete", handler.DeleteSeries).Methods("POST")
+	protected.HandleFunc("/series/{id}/parts", handler.UpdateSeriesParts).Methods("POST")
+
+	// Соавторы и другие участники работы
+	protected.HandleFunc("/books/{id}/authors", handler.InviteAuthor).Methods("POST")
+	protected.HandleFunc("/books/{id}/authors/accept", handler.AcceptAuthorInvite).Methods("POST")
+	protected.HandleFunc("/books/{id}/authors/leave", handler.LeaveBook).Methods("POST")
+	protected.HandleFunc("/books/{id}/authors/{userID}/remove", handler.RemoveAuthor).Methods("POST")
+
+	// Черновики и отложенная публикация
+	protected.HandleFunc("/books/{id}/publish", handler.PublishBook).Methods("POST")
+	protected.HandleFunc("/books/{id}/schedule", handler.ScheduleBook).Methods("POST")
+	protected.HandleFunc("/books/{id}/unpublish", handler.UnpublishBook).Methods("POST")
+
+	// История правок
+	protected.HandleFunc("/books/{id}/history", handler.BookHistory).Methods("GET")
+	protected.HandleFunc("/books/{id}/revisions/{number}/restore", handler.RestoreRevision).Methods("POST")
+
+	protected.HandleFunc("/books/{id}/shelve", handler.ShelveBook).Methods("POST")
+	protected.HandleFunc("/books/{id}/unshelve", handler.UnshelveBook).Methods("POST")
+
+	// Уведомления
+	protected.HandleFunc("/notifications", handler.NotificationsPage).Methods("GET")
+	protected.HandleFunc("/notifications/read-all", handler.MarkAllNotificationsRead).Methods("POST")
+	protected.HandleFunc("/notifications/{id}", handler.OpenNotification).Methods("GET")
+	protected.HandleFunc("/notifications/{id}/read", handler.MarkNotificationRead).Methods("POST")
+	protected.HandleFunc("/settings/notifications", handler.NotificationSettingsPage).Methods("GET")
+	protected.HandleFunc("/settings/notifications", handler.UpdateNotificationSettings).Methods("POST")
+
+	// Подписки на авторов и работы
+	protected.HandleFunc("/users/{username}/follow", handler.FollowUser).Methods("POST")
+	protected.HandleFunc("/users/{username}/unfollow", handler.UnfollowUser).Methods("POST")
+	protected.HandleFunc("/books/{id}/subscribe", handler.SubscribeBook).Methods("POST")
+	protected.HandleFunc("/books/{id}/unsubscribe", handler.UnsubscribeBook).Methods("POST")
+	protected.HandleFunc("/tags/follow", handler.FollowTag).Methods("POST")
+	protected.HandleFunc("/users/{username}/block", handler.BlockUser).Methods("POST")
+	protected.HandleFunc("/users/{username}/unblock", handler.UnblockUser).Methods("POST")
+	protected.HandleFunc("/users/{username}/mute", handler.MuteUser).Methods("POST")
+	protected.HandleFunc("/users/{username}/unmute", handler.UnmuteUser).Methods("POST")
+	protected.HandleFunc("/settings/blocked", handler.BlockedUsersPage).Methods("GET")
+	protected.HandleFunc("/settings/filters", handler.ContentFilterPage).Methods("GET")
+	protected.HandleFunc("/settings/filters", handler.UpdateContentFilter).Methods("POST")
+	protected.HandleFunc("/messages", handler.InboxPage).Methods("GET")
+	protected.HandleFunc("/messages", handler.StartConversation).Methods("POST")
+	protected.HandleFunc("/messages/new", handler.NewMessagePage).Methods("GET")
+	protected.HandleFunc("/messages/{id}", handler.ConversationPage).Methods("GET")
+	protected.HandleFunc("/messages/{id}", handler.ReplyMessage).Methods("POST")
+	protected.HandleFunc("/tags/unfollow", handler.UnfollowTag).Methods("POST")
+
 	// Запуск сервера
 	port := ":8080"
 	sugar.Infow("Starting server",
@@ -151,7 +374,7 @@ func initDB() (*sql.DB, error) {
 	// Создаем директорию если не существует
 	os.MkdirAll("data", 0755)
 	
-	db, err := sql.Open("sqlite3", "data/app.db")
+	db, err := sql.Open("sqlite3", "data/app.db?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -178,6 +401,9 @@ func createTables(db *sql.DB) error {
 			email VARCHAR(100) UNIQUE NOT NULL,
 			password VARCHAR(255) NOT NULL,
 			avatar VARCHAR(255) DEFAULT '',
+			role VARCHAR(20) DEFAULT 'user',
+			bio TEXT DEFAULT '',
+			view_adult BOOLEAN DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)
 	`)
@@ -197,10 +423,24 @@ func createTables(db *sql.DB) error {
 			file_size INTEGER NOT NULL,
 			cover_image VARCHAR(500),
 			tags TEXT DEFAULT '',
+			content_rating VARCHAR(2) DEFAULT 'NR',
+			warnings TEXT DEFAULT '',
+			status VARCHAR(20) DEFAULT 'in_progress',
+			word_count INTEGER DEFAULT 0,
+			chapter_count INTEGER DEFAULT 0,
+			char_count INTEGER DEFAULT 0,
+			dialogue_ratio FLOAT DEFAULT 0,
+			avg_sentence_length FLOAT DEFAULT 0,
+			language VARCHAR(10) DEFAULT 'ru',
+			visibility VARCHAR(10) DEFAULT 'public',
+			draft BOOLEAN DEFAULT 0,
+			published_at DATETIME,
 			rating FLOAT DEFAULT 0,
 			rating_count INTEGER DEFAULT 0,
+			kudos_count INTEGER DEFAULT 0,
 			user_id INTEGER NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 		)
 	`)
@@ -225,12 +465,485 @@ func createTables(db *sql.DB) error {
 		return fmt.Errorf("failed to create ratings table: %v", err)
 	}
 
+	// Таблица тегов: синонимы ссылаются на канонический тег через merger_id
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(100) NOT NULL,
+			normalized VARCHAR(100) UNIQUE NOT NULL,
+			category VARCHAR(20) DEFAULT 'freeform',
+			canonical BOOLEAN DEFAULT 0,
+			merger_id INTEGER,
+			usage_count INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (merger_id) REFERENCES tags (id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tags table: %v", err)
+	}
+
+	// Иерархия тегов (персонаж принадлежит фандому)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tag_parents (
+			parent_id INTEGER NOT NULL,
+			child_id INTEGER NOT NULL,
+			PRIMARY KEY (parent_id, child_id),
+			FOREIGN KEY (parent_id) REFERENCES tags (id) ON DELETE CASCADE,
+			FOREIGN KEY (child_id) REFERENCES tags (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tag_parents table: %v", err)
+	}
+
+	// Связь книг с тегами
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_tags (
+			book_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (book_id, tag_id),
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create book_tags table: %v", err)
+	}
+
+	// Счетчик использования тегов для автодополнения считает только работы
+	// в открытых списках, поэтому пересчитывается и при смене видимости или
+	// публикации работы. Прежние триггеры считали все работы.
+	triggers := []string{
+		`DROP TRIGGER IF EXISTS trg_book_tags_insert`,
+		`DROP TRIGGER IF EXISTS trg_book_tags_delete`,
+		`CREATE TRIGGER IF NOT EXISTS trg_book_tags_listed_insert AFTER INSERT ON book_tags
+		BEGIN
+			UPDATE tags SET usage_count = ` + models.TagUsageCountQuery + ` WHERE id = NEW.tag_id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS trg_book_tags_listed_delete AFTER DELETE ON book_tags
+		BEGIN
+			UPDATE tags SET usage_count = ` + models.TagUsageCountQuery + ` WHERE id = OLD.tag_id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS trg_books_listed_update AFTER UPDATE OF draft, visibility ON books
+		WHEN OLD.draft != NEW.draft OR OLD.visibility != NEW.visibility
+		BEGIN
+			UPDATE tags SET usage_count = ` + models.TagUsageCountQuery + `
+			WHERE id IN (SELECT tag_id FROM book_tags WHERE book_id = NEW.id);
+		END`,
+		// Части цикла нумеруются подряд - и когда часть убирают из цикла,
+		// и когда работу удаляют вместе с ее записями
+		`CREATE TRIGGER IF NOT EXISTS trg_series_books_delete AFTER DELETE ON series_books
+		BEGIN
+			UPDATE series_books SET position = position - 1
+			WHERE series_id = OLD.series_id AND position > OLD.position;
+		END`,
+	}
+
+	// Сохраненные поиски; last_seen_at - время публикации последней работы,
+	// которую пользователь видел в выдаче этого поиска, last_digest_at и
+	// last_notified_at - то же для письма и уведомления на сайте,
+	// filter_params - структурные фильтры строкой запроса
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS saved_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			query TEXT DEFAULT '',
+			tags TEXT DEFAULT '',
+			sort VARCHAR(20) DEFAULT '',
+			filter_params TEXT DEFAULT '',
+			email_digest BOOLEAN DEFAULT 0,
+			last_seen_at DATETIME,
+			last_digest_at DATETIME,
+			last_notified_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create saved_searches table: %v", err)
+	}
+
+	// Комментарии к работам; chapter = 0 - обсуждение работы целиком.
+	// deleted оставляет в ветке удаленный комментарий, на который уже ответили
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			parent_id INTEGER,
+			chapter INTEGER DEFAULT 0,
+			body TEXT NOT NULL,
+			hidden BOOLEAN DEFAULT 0,
+			deleted BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (parent_id) REFERENCES comments (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create comments table: %v", err)
+	}
+
+	// Кудосы: один от пользователя на работу; гостевые различаются по хэшу IP
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS kudos (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id INTEGER NOT NULL,
+			user_id INTEGER,
+			ip_hash VARCHAR(64),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create kudos table: %v", err)
+	}
+
+	// Полки пользователей: стандартные полки статуса чтения и свои коллекции
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS shelves (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			kind VARCHAR(20) DEFAULT 'custom',
+			privacy VARCHAR(20) DEFAULT 'private',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create shelves table: %v", err)
+	}
+
+	// Книги на полках; note - личная заметка владельца полки
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS shelf_books (
+			shelf_id INTEGER NOT NULL,
+			book_id INTEGER NOT NULL,
+			note TEXT DEFAULT '',
+			added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (shelf_id, book_id),
+			FOREIGN KEY (shelf_id) REFERENCES shelves (id) ON DELETE CASCADE,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create shelf_books table: %v", err)
+	}
+
+	// Подписки на авторов
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS follows (
+			follower_id INTEGER NOT NULL,
+			author_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (follower_id, author_id),
+			FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create follows table: %v", err)
+	}
+
+	// Подписки на обновления отдельных работ
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS work_subscriptions (
+			user_id INTEGER NOT NULL,
+			book_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, book_id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create work_subscriptions table: %v", err)
+	}
+
+	// Подписки на теги для персональной ленты; хранится канонический тег
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS tag_follows (
+			user_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, tag_id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tag_follows table: %v", err)
+	}
+
+	// Блокировки: заблокированный не может писать блокирующему, комментировать
+	// его работы и смотреть его профиль
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS blocks (
+			blocker_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (blocker_id, blocked_id),
+			FOREIGN KEY (blocker_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (blocked_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create blocks table: %v", err)
+	}
+
+	// Заглушенные авторы: их работы скрыты из выдачи заглушившего
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mutes (
+			muter_id INTEGER NOT NULL,
+			muted_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (muter_id, muted_id),
+			FOREIGN KEY (muter_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (muted_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create mutes table: %v", err)
+	}
+
+	// Личные переписки; created_by нужен для ограничения числа новых переписок
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create conversations table: %v", err)
+	}
+
+	// Участники переписки; last_read_id - последнее прочитанное сообщение
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS conversation_participants (
+			conversation_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			last_read_id INTEGER DEFAULT 0,
+			PRIMARY KEY (conversation_id, user_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation_participants table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create messages table: %v", err)
+	}
+
+	// Уведомления; message хранится готовым текстом, имя автора действия
+	// подставляется при выводе по actor_id
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type VARCHAR(30) NOT NULL,
+			actor_id INTEGER,
+			book_id INTEGER,
+			message TEXT NOT NULL,
+			link VARCHAR(255) DEFAULT '',
+			read BOOLEAN DEFAULT 0,
+			emailed BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications table: %v", err)
+	}
+
+	// Настройки доставки уведомлений на почту; отсутствующая строка - значение по умолчанию
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_preferences (
+			user_id INTEGER NOT NULL,
+			type VARCHAR(30) NOT NULL,
+			delivery VARCHAR(20) NOT NULL,
+			PRIMARY KEY (user_id, type),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_preferences table: %v", err)
+	}
+
+	// Время последней отправки дайджеста каждого вида
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS digest_log (
+			user_id INTEGER NOT NULL,
+			delivery VARCHAR(20) NOT NULL,
+			sent_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, delivery),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create digest_log table: %v", err)
+	}
+
+	// Циклы работ; position - номер части в порядке чтения, начиная с 1
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS series (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			title VARCHAR(200) NOT NULL,
+			description TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create series table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS series_books (
+			series_id INTEGER NOT NULL,
+			book_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (series_id, book_id),
+			FOREIGN KEY (series_id) REFERENCES series (id) ON DELETE CASCADE,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create series_books table: %v", err)
+	}
+
+	// Участники работы помимо владельца (books.user_id): соавторы, переводчики,
+	// беты, иллюстраторы. status - pending, пока приглашение не принято.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_authors (
+			book_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role VARCHAR(20) NOT NULL,
+			status VARCHAR(10) NOT NULL DEFAULT 'pending',
+			invited_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (book_id, user_id),
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (invited_by) REFERENCES users (id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create book_authors table: %v", err)
+	}
+
+	// Личные фильтры читателя; списки значений хранятся через запятую
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS content_filters (
+			user_id INTEGER PRIMARY KEY,
+			ratings TEXT DEFAULT '',
+			warnings TEXT DEFAULT '',
+			tags TEXT DEFAULT '',
+			languages TEXT DEFAULT '',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create content_filters table: %v", err)
+	}
+
+	// Переводы: оригинал либо опубликован на сайте (original_book_id), либо
+	// описан названием, автором и ссылкой; permission - разрешение автора
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS translations (
+			book_id INTEGER PRIMARY KEY,
+			original_book_id INTEGER,
+			original_title VARCHAR(255) NOT NULL,
+			original_author VARCHAR(255) DEFAULT '',
+			original_url VARCHAR(500) DEFAULT '',
+			permission VARCHAR(20) NOT NULL DEFAULT 'not_asked',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (original_book_id) REFERENCES books (id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create translations table: %v", err)
+	}
+
+	// Версии текста работ: каждое сохранение хранит содержимое целиком,
+	// чтобы любую версию можно было сравнить и восстановить
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS book_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			book_id INTEGER NOT NULL,
+			number INTEGER NOT NULL,
+			content_hash VARCHAR(64) NOT NULL,
+			content BLOB NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			user_id INTEGER,
+			note VARCHAR(500) DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (book_id, number),
+			FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create book_revisions table: %v", err)
+	}
+
 	// Создаем индексы
 	indexes := []string{
 		`CREATE INDEX IF NOT EXISTS idx_books_search ON books(title, author, description, tags)`,
 		`CREATE INDEX IF NOT EXISTS idx_books_user ON books(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_books_user_created ON books(user_id, created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_ratings_user_book ON ratings(user_id, book_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_ratings_book ON ratings(book_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_merger ON tags(merger_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_tag_parents_child ON tag_parents(child_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_book_tags_tag ON book_tags(tag_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_normalized ON tags(normalized)`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_searches_user ON saved_searches(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_comments_book ON comments(book_id, chapter)`,
+		`CREATE INDEX IF NOT EXISTS idx_comments_parent ON comments(parent_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_kudos_book_user ON kudos(book_id, user_id)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_kudos_book_ip ON kudos(book_id, ip_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_shelf_books_book ON shelf_books(book_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_follows_author ON follows(author_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_work_subscriptions_book ON work_subscriptions(book_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, read)`,
+		`CREATE INDEX IF NOT EXISTS idx_blocks_blocked ON blocks(blocked_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_conversations_creator ON conversations(created_by, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_conversation_participants_user ON conversation_participants(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_sender ON messages(sender_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_series_user ON series(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_series_books_book ON series_books(book_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_book_authors_user ON book_authors(user_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_translations_original ON translations(original_book_id)`,
 	}
 
 	for _, index := range indexes {
@@ -245,11 +958,83 @@ func createTables(db *sql.DB) error {
 		`ALTER TABLE books ADD COLUMN tags TEXT DEFAULT ''`,
 		`ALTER TABLE books ADD COLUMN rating FLOAT DEFAULT 0`,
 		`ALTER TABLE books ADD COLUMN rating_count INTEGER DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN role VARCHAR(20) DEFAULT 'user'`,
+		`ALTER TABLE tags ADD COLUMN usage_count INTEGER DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN kudos_count INTEGER DEFAULT 0`,
+		`ALTER TABLE notifications ADD COLUMN emailed BOOLEAN DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN updated_at DATETIME`,
+		`ALTER TABLE users ADD COLUMN bio TEXT DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN content_rating VARCHAR(2) DEFAULT 'NR'`,
+		`ALTER TABLE books ADD COLUMN warnings TEXT DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN view_adult BOOLEAN DEFAULT 0`,
+		// Индексы по новым полям создаем после того, как поля добавлены
+		`CREATE INDEX IF NOT EXISTS idx_books_content_rating ON books(content_rating)`,
+		`ALTER TABLE books ADD COLUMN status VARCHAR(20) DEFAULT 'in_progress'`,
+		`ALTER TABLE books ADD COLUMN word_count INTEGER DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN chapter_count INTEGER DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN char_count INTEGER DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN dialogue_ratio FLOAT DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN avg_sentence_length FLOAT DEFAULT 0`,
+		`CREATE INDEX IF NOT EXISTS idx_books_status ON books(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_books_word_count ON books(word_count)`,
+		`ALTER TABLE books ADD COLUMN language VARCHAR(10) DEFAULT 'ru'`,
+		`CREATE INDEX IF NOT EXISTS idx_books_language ON books(language)`,
+		`ALTER TABLE content_filters ADD COLUMN languages TEXT DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN visibility VARCHAR(10) DEFAULT 'public'`,
+		`ALTER TABLE books ADD COLUMN published_at DATETIME`,
+		`ALTER TABLE books ADD COLUMN draft BOOLEAN DEFAULT 0`,
+		`ALTER TABLE saved_searches ADD COLUMN filter_params TEXT DEFAULT ''`,
+		`ALTER TABLE saved_searches ADD COLUMN last_seen_at DATETIME`,
+		`ALTER TABLE saved_searches ADD COLUMN last_digest_at DATETIME`,
+		`ALTER TABLE saved_searches ADD COLUMN last_notified_at DATETIME`,
+		// Работы, загруженные до появления черновиков, считаются
+		// опубликованными в момент загрузки
+		`UPDATE books SET published_at = created_at WHERE published_at IS NULL AND draft = 0`,
+		`CREATE INDEX IF NOT EXISTS idx_books_visibility ON books(visibility, published_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_books_draft ON books(draft, published_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_books_file_path ON books(file_path)`,
+		// У каждой работы свой файл. В старых базах путь мог повторяться,
+		// и тогда уникальный индекс не создастся; поиск по пути остается
+		// на обычном индексе, а новые загрузки все равно получают
+		// уникальные пути
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_books_file_path_unique ON books(file_path)`,
+		`UPDATE books SET updated_at = created_at WHERE updated_at IS NULL`,
 	}
 
 	for _, alter := range alterStatements {
 		db.Exec(alter) // Игнорируем ошибки если поля уже существуют
 	}
 
+	// Триггеры ссылаются на новые поля, поэтому создаем их после ALTER
+	for _, trigger := range triggers {
+		_, err = db.Exec(trigger)
+		if err != nil {
+			return fmt.Errorf("failed to create trigger: %v", err)
+		}
+	}
+
 	return nil
-}
\ No newline at end of file
+}
+
+// loadSecret возвращает секрет приложения из APP_SECRET, а если он не задан -
+// из файла data/secret.key, создавая его при первом запуске
+func loadSecret(logger *zap.SugaredLogger) string {
+	if secret := os.Getenv("APP_SECRET"); secret != "" {
+		return secret
+	}
+
+	const secretFile = "data/secret.key"
+	if data, err := os.ReadFile(secretFile); err == nil && len(data) > 0 {
+		return strings.TrimSpace(string(data))
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Fatal("Failed to generate secret:", err)
+	}
+	secret := hex.EncodeToString(buf)
+	if err := os.WriteFile(secretFile, []byte(secret), 0600); err != nil {
+		logger.Error("Failed to save secret:", err)
+	}
+	return secret
+}
@@ -0,0 +1,284 @@
+package digest
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"sob/pkg/mailer"
+	"sob/pkg/models"
+	"sob/pkg/notify"
+
+	"go.uber.org/zap"
+)
+
+// schedule задает, как часто пользователь может получать письмо каждого
+// вида; period - модификатор даты SQLite
+var schedule = []struct {
+	Delivery string
+	Period   string
+	Subject  string
+}{
+	{models.DeliveryImmediate, "", "BookFan: new notifications"},
+	{models.DeliveryDaily, "-1 day", "BookFan: daily digest"},
+	{models.DeliveryWeekly, "-7 days", "BookFan: weekly digest"},
+}
+
+// Scheduler периодически собирает непрочитанные уведомления в письма.
+// Новые результаты сохраненных поисков с включенной рассылкой попадают
+// в ежедневный дайджест. Кроме того, о новых результатах любого
+// сохраненного поиска пользователь получает уведомление на сайте.
+type Scheduler struct {
+	Notifications *models.NotificationRepo
+	Notifier      *notify.Service
+	SavedSearches *models.SavedSearchRepo
+	Books         *models.BookRepo
+	Users         *models.UserRepo
+	Mailer        mailer.Mailer
+	Logger        *zap.SugaredLogger
+	BaseURL       string
+	Secret        string
+	Interval      time.Duration
+
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// digestSearch - сохраненный поиск с числом новых совпадений для письма
+type digestSearch struct {
+	Search   *models.SavedSearch
+	NewCount int
+	URL      string
+}
+
+type digestItem struct {
+	Notification *models.Notification
+	URL          string
+}
+
+// LoadTemplates разбирает шаблоны писем из каталога dir
+func (s *Scheduler) LoadTemplates(dir string) error {
+	var err error
+	s.html, err = htmltemplate.ParseFiles(filepath.Join(dir, "digest.html"))
+	if err != nil {
+		return err
+	}
+	s.text, err = texttemplate.ParseFiles(filepath.Join(dir, "digest.txt"))
+	return err
+}
+
+// Run запускает рассылку до закрытия stop
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RunOnce уведомляет о новых результатах сохраненных поисков и отправляет
+// все письма, которые пора отправить
+func (s *Scheduler) RunOnce() {
+	s.notifySavedSearches()
+
+	var searches map[int][]*models.SavedSearch
+	if all, err := s.SavedSearches.GetDigestSearches(); err != nil {
+		s.Logger.Error("Get digest saved searches error:", err)
+	} else {
+		searches = make(map[int][]*models.SavedSearch)
+		for _, search := range all {
+			searches[search.UserID] = append(searches[search.UserID], search)
+		}
+	}
+
+	for _, entry := range schedule {
+		userIDs, err := s.Notifications.PendingEmailUsers(entry.Delivery)
+		if err != nil {
+			s.Logger.Error("Get pending email users error:", err)
+			continue
+		}
+
+		if entry.Delivery == models.DeliveryDaily {
+			seen := make(map[int]bool, len(userIDs))
+			for _, id := range userIDs {
+				seen[id] = true
+			}
+			for id := range searches {
+				if !seen[id] {
+					userIDs = append(userIDs, id)
+				}
+			}
+		}
+
+		for _, userID := range userIDs {
+			if entry.Period != "" {
+				due, err := s.Notifications.DigestDue(userID, entry.Delivery, entry.Period)
+				if err != nil {
+					s.Logger.Error("Check digest due error:", err)
+					continue
+				}
+				if !due {
+					continue
+				}
+			}
+
+			var userSearches []*models.SavedSearch
+			if entry.Delivery == models.DeliveryDaily {
+				userSearches = searches[userID]
+			}
+			if err := s.send(userID, entry.Delivery, entry.Subject, userSearches); err != nil {
+				s.Logger.Error("Send digest error:", err)
+			}
+		}
+	}
+}
+
+// notifySavedSearches создает уведомления о работах, появившихся в выдаче
+// сохраненных поисков с прошлого просмотра или уведомления. Уведомления
+// создаются раньше писем, поэтому при мгновенной доставке уходят в том же
+// проходе.
+func (s *Scheduler) notifySavedSearches() {
+	searches, err := s.SavedSearches.GetNotifySearches()
+	if err != nil {
+		s.Logger.Error("Get saved searches error:", err)
+		return
+	}
+	if len(searches) == 0 {
+		return
+	}
+
+	latestAt, err := s.Books.LatestPublishedAt()
+	if err != nil {
+		s.Logger.Error("Get latest publication error:", err)
+		return
+	}
+
+	for _, search := range searches {
+		if search.LastSeenAt >= latestAt {
+			continue
+		}
+		count, err := s.Books.CountNewMatches(search.UserID, search.Query, models.SplitTags(search.Tags), search.Filter(), search.LastSeenAt)
+		if err != nil {
+			s.Logger.Error("Count saved search matches error:", err)
+			continue
+		}
+		if count > 0 {
+			s.Notifier.SavedSearchMatched(search, count)
+		}
+		if err := s.SavedSearches.MarkNotified(search.ID, latestAt); err != nil {
+			s.Logger.Error("Mark saved search notified error:", err)
+		}
+	}
+}
+
+func (s *Scheduler) send(userID int, delivery, subject string, searches []*models.SavedSearch) error {
+	notifications, err := s.Notifications.PendingEmail(userID, delivery)
+	if err != nil {
+		return err
+	}
+
+	latestAt, err := s.Books.LatestPublishedAt()
+	if err != nil {
+		return err
+	}
+
+	var matched []*digestSearch
+	for _, search := range searches {
+		count, err := s.Books.CountNewMatches(search.UserID, search.Query, models.SplitTags(search.Tags), search.Filter(), search.LastSeenAt)
+		if err != nil {
+			s.Logger.Error("Count saved search matches error:", err)
+			continue
+		}
+		if count > 0 {
+			matched = append(matched, &digestSearch{
+				Search:   search,
+				NewCount: count,
+				URL:      s.BaseURL + "/saved-searches/" + strconv.Itoa(search.ID),
+			})
+		}
+	}
+
+	if len(notifications) == 0 && len(matched) == 0 {
+		return nil
+	}
+
+	user, err := s.Users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*digestItem, len(notifications))
+	types := map[string]bool{}
+	for i, n := range notifications {
+		items[i] = &digestItem{Notification: n, URL: s.BaseURL + n.Link}
+		types[n.Type] = true
+	}
+
+	// Ссылки отписки от каждого типа, попавшего в письмо
+	unsubscribe := map[string]string{}
+	for t := range types {
+		unsubscribe[t] = UnsubscribeURL(s.BaseURL, s.Secret, userID, t)
+	}
+	if len(matched) > 0 {
+		unsubscribe[UnsubscribeSavedSearches] = UnsubscribeURL(s.BaseURL, s.Secret, userID, UnsubscribeSavedSearches)
+	}
+	unsubscribeAll := UnsubscribeURL(s.BaseURL, s.Secret, userID, UnsubscribeAll)
+
+	data := map[string]interface{}{
+		"User":           user,
+		"Subject":        subject,
+		"Items":          items,
+		"Searches":       matched,
+		"Unsubscribe":    unsubscribe,
+		"UnsubscribeAll": unsubscribeAll,
+		"SettingsURL":    s.BaseURL + "/settings/notifications",
+	}
+
+	var html, text bytes.Buffer
+	if err := s.html.Execute(&html, data); err != nil {
+		return err
+	}
+	if err := s.text.Execute(&text, data); err != nil {
+		return err
+	}
+
+	err = s.Mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeAll + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+	if err := s.Notifications.MarkEmailed(ids); err != nil {
+		return err
+	}
+	for _, m := range matched {
+		if err := s.SavedSearches.MarkDigested(m.Search.ID, latestAt); err != nil {
+			return err
+		}
+	}
+	if delivery != models.DeliveryImmediate {
+		return s.Notifications.MarkDigestSent(userID, delivery)
+	}
+	return nil
+}
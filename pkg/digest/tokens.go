@@ -0,0 +1,39 @@
+package digest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// UnsubscribeAll отключает все письма пользователя, включая рассылку
+// сохраненных поисков
+const (
+	UnsubscribeAll           = "all"
+	UnsubscribeSavedSearches = "saved_searches"
+)
+
+// UnsubscribeToken подписывает пару (пользователь, тип уведомлений), чтобы
+// ссылку отписки нельзя было подделать для чужого аккаунта
+func UnsubscribeToken(secret string, userID int, notificationType string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "unsubscribe:%d:%s", userID, notificationType)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyUnsubscribeToken(secret string, userID int, notificationType, token string) bool {
+	expected := UnsubscribeToken(secret, userID, notificationType)
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+// UnsubscribeURL возвращает ссылку отписки в один клик
+func UnsubscribeURL(baseURL, secret string, userID int, notificationType string) string {
+	params := url.Values{}
+	params.Set("u", strconv.Itoa(userID))
+	params.Set("type", notificationType)
+	params.Set("token", UnsubscribeToken(secret, userID, notificationType))
+	return baseURL + "/unsubscribe?" + params.Encode()
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"sync"
+)
+
+type Type string
+
+const (
+	// BookPublished - автор загрузил новую работу
+	BookPublished Type = "book_published"
+	// BookUpdated - автор обновил работу через форму редактирования
+	BookUpdated Type = "book_updated"
+)
+
+// Event описывает произошедшее на сайте действие. Получателей событие не
+// знает: их определяют подписчики шины (уведомления, рассылки и т.п.)
+type Event struct {
+	Type      Type
+	ActorID   int
+	BookID    int
+	BookTitle string
+}
+
+type Listener func(Event)
+
+// Bus синхронно раздает события всем подписчикам. Паника одного подписчика
+// не мешает остальным и не роняет обработчик запроса.
+type Bus struct {
+	mu        sync.RWMutex
+	listeners []Listener
+	onPanic   func(recovered interface{})
+}
+
+func NewBus(onPanic func(recovered interface{})) *Bus {
+	return &Bus{onPanic: onPanic}
+}
+
+func (b *Bus) Subscribe(listener Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	listeners := make([]Listener, len(b.listeners))
+	copy(listeners, b.listeners)
+	b.mu.RUnlock()
+
+	for _, listener := range listeners {
+		b.deliver(listener, event)
+	}
+}
+
+func (b *Bus) deliver(listener Listener, event Event) {
+	defer func() {
+		if rec := recover(); rec != nil && b.onPanic != nil {
+			b.onPanic(rec)
+		}
+	}()
+	listener(event)
+}
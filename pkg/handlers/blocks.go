@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+// Действия со страницы автора и со страницы настроек
+const (
+	actionBlock   = "block"
+	actionUnblock = "unblock"
+	actionMute    = "mute"
+	actionUnmute  = "unmute"
+)
+
+func (h *Handler) BlockUser(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, actionBlock)
+}
+
+func (h *Handler) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, actionUnblock)
+}
+
+func (h *Handler) MuteUser(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, actionMute)
+}
+
+func (h *Handler) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, actionUnmute)
+}
+
+func (h *Handler) setBlock(w http.ResponseWriter, r *http.Request, action string) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	target, err := h.UserRepo.GetByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	userID := int(sess.UserID)
+	switch action {
+	case actionBlock:
+		err = h.BlockRepo.Block(userID, target.ID)
+	case actionUnblock:
+		err = h.BlockRepo.Unblock(userID, target.ID)
+	case actionMute:
+		err = h.BlockRepo.Mute(userID, target.ID)
+	case actionUnmute:
+		err = h.BlockRepo.Unmute(userID, target.ID)
+	}
+	if err == models.ErrSelfBlock {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Update block error:", err)
+		http.Error(w, "Failed to update block", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/settings/blocked")
+}
+
+// BlockedUsersPage показывает заблокированных и заглушенных пользователей
+func (h *Handler) BlockedUsersPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	blocked, err := h.BlockRepo.GetBlocked(user.ID)
+	if err != nil {
+		h.Logger.Error("Get blocked users error:", err)
+	}
+	muted, err := h.BlockRepo.GetMuted(user.ID)
+	if err != nil {
+		h.Logger.Error("Get muted users error:", err)
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "blocked_users.html", map[string]interface{}{
+		"User":    user,
+		"Blocked": blocked,
+		"Muted":   muted,
+	})
+}
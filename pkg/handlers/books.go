@@ -3,12 +3,14 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
+	"sob/pkg/events"
 	"sob/pkg/models"
 	"sob/pkg/session"
 	"sob/pkg/utils"
@@ -25,10 +27,38 @@ func (h *Handler) UploadPage(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := h.UserRepo.GetByID(int(sess.UserID))
 	h.Tmpl.ExecuteTemplate(w, "upload.html", map[string]interface{}{
-		"User": user,
+		"User":         user,
+		"Ratings":      models.ContentRatings,
+		"Warnings":     models.ContentWarnings,
+		"Statuses":     models.WorkStatuses,
+		"Languages":    models.Languages,
+		"Permissions":  models.TranslationPermissions,
+		"Visibilities": models.Visibilities,
 	})
 }
 
+// contentRatingForm читает обязательные рейтинг и предупреждения из формы
+func contentRatingForm(r *http.Request) (rating, warnings string, err error) {
+	rating = r.FormValue("content_rating")
+	if !models.ValidRating(rating) {
+		return "", "", models.ErrBadRating
+	}
+	warnings, err = models.NormalizeWarnings(r.Form["warnings"])
+	return rating, warnings, err
+}
+
+// workStatusForm читает статус работы; по умолчанию работа в процессе
+func workStatusForm(r *http.Request) (string, error) {
+	status := r.FormValue("status")
+	if status == "" {
+		return models.StatusInProgress, nil
+	}
+	if models.WorkStatusLabel(status) == "" {
+		return "", models.ErrBadStatus
+	}
+	return status, nil
+}
+
 func (h *Handler) UploadBook(w http.ResponseWriter, r *http.Request) {
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
@@ -43,6 +73,37 @@ func (h *Handler) UploadBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	contentRating, warnings, err := contentRatingForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := workStatusForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	language, err := languageForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	translation, err := h.translationForm(r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visibility, err := visibilityForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	draft, publishedAt, err := publishForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	file, header, err := r.FormFile("book_file")
 	if err != nil {
 		h.Logger.Error("Get book file error:", err)
@@ -80,11 +141,11 @@ func (h *Handler) UploadBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 
-	filename := fmt.Sprintf("%d_%s", sess.UserID, header.Filename)
+	filename := uploadFilename(int(sess.UserID), header.Filename)
 	filePath := filepath.Join(h.UploadDir, filename)
 
 
-	dst, err := os.Create(filePath)
+	dst, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		h.Logger.Error("Create file error:", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
@@ -101,18 +162,28 @@ func (h *Handler) UploadBook(w http.ResponseWriter, r *http.Request) {
 
 	// Create book record
 	book := &models.Book{
-		Title:       r.FormValue("title"),
-		Author:      r.FormValue("author"),
-		Description: r.FormValue("description"),
-		Tags:        r.FormValue("tags"),
-		Filename:    header.Filename,
-		FilePath:    filePath,
-		FileSize:    header.Size,
-		CoverImage:  coverPath,
-		UserID:      int(sess.UserID),
-	}
-
-	_, err = h.BookRepo.Create(book)
+		Title:         r.FormValue("title"),
+		Author:        r.FormValue("author"),
+		Description:   r.FormValue("description"),
+		Tags:          r.FormValue("tags"),
+		ContentRating: contentRating,
+		Warnings:      warnings,
+		Status:        status,
+		Language:      language,
+		Visibility:    visibility,
+		Draft:         draft,
+		PublishedAt:   publishedAt,
+		Filename:      header.Filename,
+		FilePath:      filePath,
+		FileSize:      header.Size,
+		CoverImage:    coverPath,
+		UserID:        int(sess.UserID),
+	}
+	// Статистику считаем по извлеченному тексту; если формат не
+	// разобрался, работа публикуется без нее
+	h.analyzeBookFile(book)
+
+	bookID, err := h.BookRepo.Create(book)
 	if err != nil {
 		h.Logger.Error("Create book record error:", err)
 		// Удаляем загруженные файлы при ошибке
@@ -124,7 +195,41 @@ func (h *Handler) UploadBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, "/profile", http.StatusFound)
+	if err := h.TagRepo.SetBookTags(int(bookID), book.Tags); err != nil {
+		h.Logger.Error("Set book tags error:", err)
+	}
+	// Оригинал уже проверен формой, так что здесь возможны только ошибки
+	// базы; работу без отметки перевода не оставляем
+	if translation != nil {
+		if err := h.saveTranslation(int(bookID), translation); err != nil {
+			h.Logger.Error("Save translation error:", err)
+			h.BookRepo.Delete(int(bookID), int(sess.UserID))
+			os.Remove(filePath)
+			if coverPath != "" {
+				os.Remove(coverPath)
+			}
+			http.Error(w, "Failed to save translation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Черновик увидят подписчики, когда его опубликуют вручную
+	// или по расписанию; о работах вне списков не оповещаем
+	if !book.IsDraft() {
+		if book.Notifiable() {
+			h.publish(events.Event{
+				Type:      events.BookPublished,
+				ActorID:   book.UserID,
+				BookID:    int(bookID),
+				BookTitle: book.Title,
+			})
+		}
+		http.Redirect(w, r, "/profile", http.StatusFound)
+		return
+	}
+
+	// Черновик открываем в предпросмотре, как его увидят читатели
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusFound)
 }
 
 func (h *Handler) BookDetail(w http.ResponseWriter, r *http.Request) {
@@ -140,13 +245,26 @@ func (h *Handler) BookDetail(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
 	}
+	if !h.canViewBook(book, sessionUserID(r)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
 
 	data := map[string]interface{}{
-		"Book": book,
+		"Book":         book,
+		"PublishError": r.URL.Query().Get("publish_error"),
+	}
+
+	if navs, err := h.SeriesRepo.NavForBook(book.ID, sessionUserID(r)); err != nil {
+		h.Logger.Error("Get series nav error:", err)
+	} else {
+		data["SeriesNav"] = navs
 	}
 
 	// Получаем пользователя из сессии и его оценку для этой книги
+	userID := 0
 	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		userID = int(sess.UserID)
 		user, err := h.UserRepo.GetByID(int(sess.UserID))
 		if err != nil {
 			h.Logger.Error("Get user by ID error:", err)
@@ -160,9 +278,65 @@ func (h *Handler) BookDetail(w http.ResponseWriter, r *http.Request) {
 			} else {
 				data["UserRating"] = userRating
 			}
+
+			// Полки пользователя с отметкой, на каких лежит эта книга
+			if err := h.ShelfRepo.EnsureDefaults(userID); err != nil {
+				h.Logger.Error("Ensure default shelves error:", err)
+			}
+			shelves, err := h.ShelfRepo.ShelvesForBook(userID, id)
+			if err != nil {
+				h.Logger.Error("Get shelves for book error:", err)
+			} else {
+				data["Shelves"] = shelves
+			}
+
+			// Подписки на автора и на работу
+			if following, err := h.FollowRepo.IsFollowing(userID, book.UserID); err != nil {
+				h.Logger.Error("Check follow error:", err)
+			} else {
+				data["FollowingAuthor"] = following
+			}
+			if subscribed, err := h.FollowRepo.IsSubscribed(userID, id); err != nil {
+				h.Logger.Error("Check subscription error:", err)
+			} else {
+				data["Subscribed"] = subscribed
+			}
 		}
 	}
 
+	// Соавторы и другие участники работы
+	h.loadBookAuthors(data, book, userID)
+	h.loadTranslations(data, book.ID, userID)
+	data["AuthorError"] = r.URL.Query().Get("author_error")
+
+	// Кудосы: отметка текущего посетителя и последние оставившие
+	giver := h.kudosGiver(r)
+	if hasKudos, err := h.BookRepo.HasKudos(id, giver); err != nil {
+		h.Logger.Error("Check kudos error:", err)
+	} else {
+		data["HasKudos"] = hasKudos
+	}
+	data["OwnWork"] = giver.UserID != 0 && giver.UserID == book.UserID
+	if givers, guests, err := h.BookRepo.GetKudosGivers(id, 50); err != nil {
+		h.Logger.Error("Get kudos givers error:", err)
+	} else {
+		data["KudosGivers"] = givers
+		data["KudosGuests"] = guests
+	}
+
+	// Комментарии: без параметра chapter показываем все обсуждение работы
+	chapter := -1
+	if c, err := strconv.Atoi(r.URL.Query().Get("chapter")); err == nil && c >= 0 {
+		chapter = c
+	}
+	comments, err := h.loadComments(book, userID, chapter)
+	if err != nil {
+		h.Logger.Error("Get comments error:", err)
+		comments = []*models.Comment{}
+	}
+	data["Comments"] = comments
+	data["Chapter"] = chapter
+
 	h.Tmpl.ExecuteTemplate(w, "book_detail.html", data)
 }
 
@@ -179,41 +353,80 @@ func (h *Handler) ReadBook(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
 	}
+	if !h.canViewBook(book, sessionUserID(r)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
 
 	data := map[string]interface{}{
 		"Book": book,
 		"Ext":  strings.ToLower(filepath.Ext(book.Filename)),
 	}
 
-	if utils.IsTextFile(book.Filename) {
-		content, err := utils.ReadBookContent(book.FilePath)
-		if err != nil {
-			h.Logger.Error("Read book file error:", err)
-			data["Content"] = "Не удалось загрузить содержимое книги"
-		} else {
-			data["Content"] = content
-			data["IsEditable"] = utils.IsEditableFormat(book.Filename)
-		}
-	}
-
 	// Получаем пользователя из сессии
+	var user *models.User
 	if sess, err := session.SessionFromContext(r.Context()); err == nil {
-		user, err := h.UserRepo.GetByID(int(sess.UserID))
+		user, err = h.UserRepo.GetByID(int(sess.UserID))
 		if err != nil {
 			h.Logger.Error("Get user by ID error:", err)
 		} else {
 			data["User"] = user
 			
 			// Проверяем, может ли пользователь редактировать книгу
-			if book.UserID == int(sess.UserID) {
+			if h.canEditBook(book, int(sess.UserID)) {
 				data["CanEdit"] = true
 			}
 		}
 	}
 
+	// Перед работой с рейтингом Explicit показываем предупреждение, если
+	// читатель не подтвердил возраст в профиле и не нажал "продолжить"
+	if book.IsExplicit() && r.URL.Query().Get("adult") != "1" {
+		isOwner := user != nil && user.ID == book.UserID
+		if !isOwner && (user == nil || !user.ViewAdult) {
+			h.Tmpl.ExecuteTemplate(w, "adult_gate.html", data)
+			return
+		}
+	}
+
+	if navs, err := h.SeriesRepo.NavForBook(book.ID, sessionUserID(r)); err != nil {
+		h.Logger.Error("Get series nav error:", err)
+	} else {
+		data["SeriesNav"] = navs
+	}
+
+	if utils.IsTextFile(book.Filename) {
+		content, err := utils.ReadBookContent(book.FilePath)
+		if err != nil {
+			h.Logger.Error("Read book file error:", err)
+			data["Content"] = "Не удалось загрузить содержимое книги"
+		} else {
+			data["Content"] = content
+			data["IsEditable"] = utils.IsEditableFormat(book.Filename)
+		}
+	}
+
 	h.Tmpl.ExecuteTemplate(w, "read_book.html", data)
 }
 
+// AllowAdultContent запоминает подтверждение возраста, чтобы предупреждение
+// перед работами Explicit больше не показывалось
+func (h *Handler) AllowAdultContent(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err := h.UserRepo.SetViewAdult(int(sess.UserID), true); err != nil {
+		h.Logger.Error("Update view adult error:", err)
+		http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/")
+}
+
 func (h *Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
@@ -282,6 +495,12 @@ func (h *Handler) RateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil || !h.canViewBook(book, int(sess.UserID)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
 	err = h.BookRepo.RateBook(int(sess.UserID), bookID, rating)
 	if err != nil {
 		h.Logger.Error("Rate book error:", err)
@@ -289,10 +508,62 @@ func (h *Handler) RateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.Notifier.BookRated(book, int(sess.UserID), rating)
+
 	// Возвращаем на страницу книги
 	http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusFound)
 }
 
+// kudosGiver определяет автора кудоса: пользователя из сессии или гостя по IP
+func (h *Handler) kudosGiver(r *http.Request) models.KudosGiver {
+	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		return models.KudosGiver{UserID: int(sess.UserID)}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return models.KudosGiver{IPHash: models.HashIP(ip, h.Secret)}
+}
+
+// GiveKudos доступен и гостям; повторный кудос просто игнорируется
+func (h *Handler) GiveKudos(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bookID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil || !h.canViewBook(book, sessionUserID(r)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	giver := h.kudosGiver(r)
+	if giver.UserID == book.UserID {
+		http.Error(w, "You can't leave kudos on your own work", http.StatusBadRequest)
+		return
+	}
+
+	added, err := h.BookRepo.GiveKudos(bookID, giver)
+	if err != nil {
+		h.Logger.Error("Give kudos error:", err)
+		http.Error(w, "Failed to leave kudos", http.StatusInternalServerError)
+		return
+	}
+
+	if added {
+		if book, err := h.BookRepo.GetByID(bookID); err == nil && book != nil {
+			h.Notifier.KudosReceived(book, book.KudosCount)
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", bookID), http.StatusFound)
+}
+
 func (h *Handler) AdvancedSearch(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
 	tagsParam := r.URL.Query().Get("tags")
@@ -303,21 +574,35 @@ func (h *Handler) AdvancedSearch(w http.ResponseWriter, r *http.Request) {
 		tags = strings.Split(tagsParam, ",")
 	}
 
-	books, err := h.BookRepo.Search(query, tags, sortBy)
+	page, err := h.BookRepo.Search(sessionUserID(r), query, tags, searchFilter(r), sortBy, pageRequest(r))
 	if err != nil {
 		h.Logger.Error("Advanced search error:", err)
-		books = []*models.Book{}
+		page = &models.BookPage{Books: []*models.Book{}}
 	}
 
 	// Получаем популярные теги для фильтра
 	popularTags, _ := h.BookRepo.GetPopularTags(20)
 
 	data := map[string]interface{}{
-		"Books":       books,
-		"Query":       query,
-		"Tags":        tags,
-		"SortBy":      sortBy,
-		"PopularTags": popularTags,
+		"Books":        page.Books,
+		"Query":        query,
+		"Tags":         tags,
+		"SortBy":       sortBy,
+		"PopularTags":  popularTags,
+		"FilterParams": searchFilter(r).Values().Encode(),
+	}
+	addPagination(data, r, page)
+	addFilterNotice(data, r, page)
+
+	if page.Facets != nil {
+		data["Facets"] = page.Facets
+		data["TagFacets"] = tagFacetLinks(r, page.Facets.Tags)
+		data["ActiveTags"] = append(activeFilterLinks(r, "tags"), activeFilterLinks(r, "with_tags")...)
+		data["RatingFacets"] = labelFacets(facetLinks(r, "rating", page.Facets.Ratings), models.ContentRatingLabel)
+		data["WarningFacets"] = labelFacets(facetLinks(r, "exclude_warnings", page.Facets.Warnings), models.ContentWarningLabel)
+		data["StatusFacets"] = labelFacets(facetLinks(r, "status", page.Facets.Statuses), models.WorkStatusLabel)
+		data["LengthFacets"] = labelFacets(facetLinks(r, "length", page.Facets.Lengths), models.LengthBucketLabel)
+		data["LanguageFacets"] = labelFacets(facetLinks(r, "language", page.Facets.Languages), models.LanguageLabel)
 	}
 
 	// Получаем пользователя из сессии
@@ -327,6 +612,8 @@ func (h *Handler) AdvancedSearch(w http.ResponseWriter, r *http.Request) {
 			h.Logger.Error("Get user by ID error:", err)
 		} else {
 			data["User"] = user
+			data["TagFollows"] = h.tagFollowStates(user.ID, tags)
+			data["CurrentURL"] = r.URL.RequestURI()
 		}
 	}
 
@@ -354,8 +641,8 @@ func (h *Handler) EditBookPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Проверяем, что пользователь - владелец книги
-	if book.UserID != int(sess.UserID) {
+	// Проверяем, что пользователь - владелец или соавтор книги
+	if !h.canEditBook(book, int(sess.UserID)) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -377,7 +664,14 @@ func (h *Handler) EditBookPage(w http.ResponseWriter, r *http.Request) {
 		"Content": content,
 		"User":    nil,
 		"CanEditContent": utils.IsEditableFormat(book.Filename),
+		"Ratings":        models.ContentRatings,
+		"Warnings":       models.ContentWarnings,
+		"Statuses":       models.WorkStatuses,
+		"Languages":      models.Languages,
+		"Permissions":    models.TranslationPermissions,
+		"Visibilities":   models.Visibilities,
 	}
+	h.loadTranslations(data, book.ID, int(sess.UserID))
 
 	user, _ := h.UserRepo.GetByID(int(sess.UserID))
 	data["User"] = user
@@ -401,14 +695,14 @@ func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Проверяем, что книга существует и принадлежит пользователю
+	// Проверяем, что книга существует и пользователь может ее редактировать
 	book, err := h.BookRepo.GetByID(id)
 	if err != nil || book == nil {
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
 	}
 
-	if book.UserID != int(sess.UserID) {
+	if !h.canEditBook(book, int(sess.UserID)) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -427,9 +721,36 @@ func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	tags := r.FormValue("tags")
 	content := r.FormValue("content")
 
-	// Обновляем содержимое файла если это текстовый формат
+	contentRating, warnings, err := contentRatingForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := workStatusForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	language, err := languageForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	translation, err := h.translationForm(r, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visibility, err := visibilityForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Обновляем содержимое файла если это текстовый формат; прежний текст
+	// остается в истории версий
 	if utils.IsTextFile(book.Filename) && content != "" {
-		err = os.WriteFile(book.FilePath, []byte(content), 0644)
+		err = h.saveRevision(book, int(sess.UserID), []byte(content), r.FormValue("change_note"))
 		if err != nil {
 			h.Logger.Error("Update book file error:", err)
 			http.Error(w, "Failed to update book content", http.StatusInternalServerError)
@@ -440,9 +761,10 @@ func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	// Обновляем информацию в базе данных
 	_, err = h.BookRepo.DB.Exec(`
 		UPDATE books 
-		SET title = ?, author = ?, description = ?, tags = ?
-		WHERE id = ? AND user_id = ?
-	`, title, author, description, tags, id, sess.UserID)
+		SET title = ?, author = ?, description = ?, tags = ?, content_rating = ?, warnings = ?,
+		    status = ?, language = ?, visibility = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, title, author, description, tags, contentRating, warnings, status, language, visibility, id)
 
 	if err != nil {
 		h.Logger.Error("Update book record error:", err)
@@ -450,5 +772,26 @@ func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.TagRepo.SetBookTags(id, tags); err != nil {
+		h.Logger.Error("Set book tags error:", err)
+	}
+	if err := h.saveTranslation(id, translation); err != nil {
+		h.Logger.Error("Save translation error:", err)
+		http.Error(w, "Failed to save translation", http.StatusInternalServerError)
+		return
+	}
+
+	// Подписчики узнают об обновлении по тому же правилу, что и о публикации
+	book.Title = title
+	book.Visibility = visibility
+	if book.Notifiable() {
+		h.publish(events.Event{
+			Type:      events.BookUpdated,
+			ActorID:   int(sess.UserID),
+			BookID:    id,
+			BookTitle: title,
+		})
+	}
+
 	http.Redirect(w, r, fmt.Sprintf("/books/%d", id), http.StatusFound)
 }
\ No newline at end of file
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+func redirectToAuthors(w http.ResponseWriter, r *http.Request, bookID int, errMsg string) {
+	target := fmt.Sprintf("/books/%d", bookID)
+	if errMsg != "" {
+		target += "?author_error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target+"#authors", http.StatusFound)
+}
+
+// canEditBook - владелец и принявшие приглашение соавторы могут менять работу
+func (h *Handler) canEditBook(book *models.Book, userID int) bool {
+	canEdit, err := h.CoAuthorRepo.CanEdit(book, userID)
+	if err != nil {
+		h.Logger.Error("Check edit permission error:", err)
+		return false
+	}
+	return canEdit
+}
+
+// loadBookAuthors добавляет на страницу работы ее участников, а зрителю -
+// его собственную запись (например, приглашение, на которое нужно ответить)
+func (h *Handler) loadBookAuthors(data map[string]interface{}, book *models.Book, userID int) {
+	authors, err := h.CoAuthorRepo.GetByBook(book.ID)
+	if err != nil {
+		h.Logger.Error("Get book authors error:", err)
+		return
+	}
+
+	var credits []*models.BookAuthor
+	for _, a := range authors {
+		if !a.IsPending() {
+			credits = append(credits, a)
+		}
+		if a.UserID == userID {
+			data["MyCredit"] = a
+			data["CanEdit"] = a.CanEdit()
+		}
+	}
+	data["Credits"] = credits
+
+	if userID != 0 && userID == book.UserID {
+		data["CanEdit"] = true
+		data["BookAuthors"] = authors
+		data["AuthorRoles"] = models.AuthorRoles
+	}
+}
+
+// InviteAuthor приглашает пользователя по имени в работу с выбранной ролью
+func (h *Handler) InviteAuthor(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	invitee, err := h.UserRepo.GetByUsername(strings.TrimSpace(r.FormValue("username")))
+	if err == models.ErrNoUser {
+		redirectToAuthors(w, r, bookID, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Get user by username error:", err)
+		http.Error(w, "Failed to invite author", http.StatusInternalServerError)
+		return
+	}
+
+	role := r.FormValue("role")
+	err = h.CoAuthorRepo.Invite(book, int(sess.UserID), invitee.ID, role)
+	switch err {
+	case nil:
+	case models.ErrNotBookOwner:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case models.ErrBadRole, models.ErrSelfInvite, models.ErrAlreadyInvited, models.ErrInviteBlocked:
+		redirectToAuthors(w, r, bookID, err.Error())
+		return
+	default:
+		h.Logger.Error("Invite author error:", err)
+		http.Error(w, "Failed to invite author", http.StatusInternalServerError)
+		return
+	}
+
+	h.Notifier.AuthorInvited(book, int(sess.UserID), invitee.ID, role)
+	redirectToAuthors(w, r, bookID, "")
+}
+
+// AcceptAuthorInvite принимает приглашение текущего пользователя в работу
+func (h *Handler) AcceptAuthorInvite(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.CoAuthorRepo.Accept(bookID, int(sess.UserID))
+	if err == models.ErrNoInvite {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Accept author invite error:", err)
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, fmt.Sprintf("/books/%d", bookID))
+}
+
+// LeaveBook отклоняет приглашение или убирает текущего пользователя из участников
+func (h *Handler) LeaveBook(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.CoAuthorRepo.Leave(bookID, int(sess.UserID)); err != nil {
+		h.Logger.Error("Leave book error:", err)
+		http.Error(w, "Failed to leave work", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, fmt.Sprintf("/books/%d", bookID))
+}
+
+// RemoveAuthor убирает участника работы или отзывает приглашение
+func (h *Handler) RemoveAuthor(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	vars := mux.Vars(r)
+	bookID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(vars["userID"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.CoAuthorRepo.Remove(book, int(sess.UserID), userID)
+	if err == models.ErrNotBookOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Remove author error:", err)
+		http.Error(w, "Failed to remove author", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToAuthors(w, r, bookID, "")
+}
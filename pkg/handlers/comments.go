@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+func commentURL(bookID, commentID int) string {
+	return fmt.Sprintf("/books/%d#comment-%d", bookID, commentID)
+}
+
+// validateCommentBody обрезает пробелы и проверяет длину текста комментария
+func validateCommentBody(body string) (string, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" || len([]rune(body)) > models.MaxCommentLength {
+		return "", false
+	}
+	return body, true
+}
+
+// loadComments строит ветку комментариев для страницы книги и выставляет
+// права текущего пользователя. Скрытые комментарии видят только автор
+// работы и автор комментария; остальным они показываются заглушкой,
+// если на них есть ответы, иначе не показываются вовсе.
+func (h *Handler) loadComments(book *models.Book, userID, chapter int) ([]*models.Comment, error) {
+	comments, err := h.CommentRepo.GetThreadByBook(book.ID, chapter)
+	if err != nil {
+		return nil, err
+	}
+
+	isOwner := userID != 0 && userID == book.UserID
+	var filter func(list []*models.Comment) []*models.Comment
+	filter = func(list []*models.Comment) []*models.Comment {
+		visible := list[:0]
+		for _, c := range list {
+			c.Replies = filter(c.Replies)
+			if c.Hidden && !isOwner && c.UserID != userID {
+				if len(c.Replies) == 0 {
+					continue
+				}
+				c.Body = ""
+			}
+
+			c.CanReply = userID != 0 && !c.Deleted
+			c.CanEdit = userID != 0 && c.UserID == userID && !c.Deleted
+			c.CanModerate = isOwner
+			visible = append(visible, c)
+		}
+		return visible
+	}
+
+	return filter(comments), nil
+}
+
+// commentForModeration загружает комментарий и книгу и проверяет, что
+// текущий пользователь - автор комментария или владелец работы
+func (h *Handler) commentForModeration(w http.ResponseWriter, r *http.Request) (*models.Comment, *models.Book, int, bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return nil, nil, 0, false
+	}
+
+	commentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return nil, nil, 0, false
+	}
+
+	comment, err := h.CommentRepo.GetByID(commentID)
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, nil, 0, false
+	}
+
+	book, err := h.BookRepo.GetByID(comment.BookID)
+	if err != nil || book == nil {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return nil, nil, 0, false
+	}
+
+	return comment, book, int(sess.UserID), true
+}
+
+func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil || !h.canViewBook(book, int(sess.UserID)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	// Автор работы мог заблокировать комментатора
+	blocked, err := h.BlockRepo.HasBlocked(book.UserID, int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Check block error:", err)
+		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+	if blocked {
+		http.Error(w, "The author does not accept your comments", http.StatusForbidden)
+		return
+	}
+
+	body, ok := validateCommentBody(r.FormValue("body"))
+	if !ok {
+		http.Error(w, "Comment must be between 1 and 10000 characters", http.StatusBadRequest)
+		return
+	}
+
+	comment := &models.Comment{
+		BookID: bookID,
+		UserID: int(sess.UserID),
+		Body:   body,
+	}
+
+	if chapter, err := strconv.Atoi(r.FormValue("chapter")); err == nil && chapter > 0 {
+		comment.Chapter = chapter
+	}
+
+	// Ответ наследует главу родительского комментария
+	var parent *models.Comment
+	if parentID, err := strconv.Atoi(r.FormValue("parent_id")); err == nil && parentID > 0 {
+		parent, err = h.CommentRepo.GetByID(parentID)
+		if err != nil || parent.BookID != bookID || parent.Deleted {
+			http.Error(w, "Invalid parent comment", http.StatusBadRequest)
+			return
+		}
+		comment.ParentID = parent.ID
+		comment.Chapter = parent.Chapter
+	}
+
+	commentID, err := h.CommentRepo.Create(comment)
+	if err != nil {
+		h.Logger.Error("Create comment error:", err)
+		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
+		return
+	}
+	comment.ID = int(commentID)
+
+	h.Notifier.CommentAdded(book, comment, parent)
+
+	http.Redirect(w, r, commentURL(bookID, int(commentID)), http.StatusFound)
+}
+
+func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	comment, book, userID, ok := h.commentForModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, ok := validateCommentBody(r.FormValue("body"))
+	if !ok {
+		http.Error(w, "Comment must be between 1 and 10000 characters", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.CommentRepo.Update(comment.ID, userID, body); err != nil {
+		h.Logger.Error("Update comment error:", err)
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, commentURL(book.ID, comment.ID), http.StatusFound)
+}
+
+// DeleteComment доступен автору комментария и владельцу работы
+func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	comment, book, userID, ok := h.commentForModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID && book.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.CommentRepo.Delete(comment.ID); err != nil {
+		h.Logger.Error("Delete comment error:", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d#comments", book.ID), http.StatusFound)
+}
+
+// HideComment скрывает комментарий или возвращает его; доступен владельцу работы
+func (h *Handler) HideComment(w http.ResponseWriter, r *http.Request) {
+	comment, book, userID, ok := h.commentForModeration(w, r)
+	if !ok {
+		return
+	}
+
+	if book.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.CommentRepo.SetHidden(comment.ID, !comment.Hidden); err != nil {
+		h.Logger.Error("Hide comment error:", err)
+		http.Error(w, "Failed to hide comment", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, commentURL(book.ID, comment.ID), http.StatusFound)
+}
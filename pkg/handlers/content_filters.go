@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+)
+
+// showFilteredParam временно отключает личный фильтр на главной и в поиске
+const showFilteredParam = models.ShowFilteredParam
+
+// addFilterNotice добавляет в шаблон счетчик работ, скрытых личным фильтром,
+// и ссылку, которая показывает их или снова прячет
+func addFilterNotice(data map[string]interface{}, r *http.Request, page *models.BookPage) {
+	if page.Hidden == 0 {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del("cursor")
+	query.Del("page")
+	showFiltered := query.Get(showFilteredParam) == "off"
+	if showFiltered {
+		query.Del(showFilteredParam)
+	} else {
+		query.Set(showFilteredParam, "off")
+	}
+
+	data["HiddenCount"] = page.Hidden
+	data["ShowFiltered"] = showFiltered
+	if encoded := query.Encode(); encoded != "" {
+		data["FilterToggleURL"] = r.URL.Path + "?" + encoded
+	} else {
+		data["FilterToggleURL"] = r.URL.Path
+	}
+}
+
+func (h *Handler) ContentFilterPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := h.UserRepo.GetContentFilter(user.ID)
+	if err != nil {
+		h.Logger.Error("Get content filter error:", err)
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	ratings := append([]models.Option{}, models.ContentRatings...)
+	ratings = append(ratings, models.Option{Value: models.RatingNotRated, Label: models.ContentRatingLabel(models.RatingNotRated)})
+
+	h.Tmpl.ExecuteTemplate(w, "content_filters.html", map[string]interface{}{
+		"User":      user,
+		"Filter":    filter,
+		"Ratings":   ratings,
+		"Warnings":  models.ContentWarnings,
+		"Languages": models.Languages,
+		"MaxTags":   models.MaxFilterTags,
+		"Saved":     r.URL.Query().Get("saved") != "",
+	})
+}
+
+func (h *Handler) UpdateContentFilter(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	r.ParseForm()
+	filter := &models.ContentFilter{
+		UserID:    int(sess.UserID),
+		Ratings:   r.Form["ratings"],
+		Warnings:  r.Form["warnings"],
+		Tags:      models.SplitTags(r.FormValue("tags")),
+		Languages: r.Form["languages"],
+	}
+	if len(filter.Tags) > models.MaxFilterTags {
+		http.Error(w, "Too many tags in filter", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.UserRepo.SaveContentFilter(filter); err != nil {
+		h.Logger.Error("Save content filter error:", err)
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings/filters?saved=1", http.StatusFound)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"sob/pkg/models"
+)
+
+// facetLink - пункт боковой панели поиска со ссылкой, которая добавляет
+// значение в фильтр или убирает его оттуда
+type facetLink struct {
+	Label  string
+	Count  int
+	URL    string
+	Active bool
+}
+
+// facetLinks строит ссылки для фасета со списком значений через запятую
+// в параметре param (например tags)
+func facetLinks(r *http.Request, param string, counts []models.FacetCount) []facetLink {
+	selected := splitParam(r.URL.Query().Get(param))
+
+	links := make([]facetLink, 0, len(counts))
+	for _, fc := range counts {
+		active := containsFold(selected, fc.Value)
+		links = append(links, facetLink{
+			Label:  fc.Value,
+			Count:  fc.Count,
+			URL:    toggleParamURL(r, param, fc.Value),
+			Active: active,
+		})
+	}
+	return links
+}
+
+// tagFacetLinks строит ссылки фасета тегов. Теги в строке поиска (tags)
+// объединяются через ИЛИ, поэтому уточнение из фасета уходит в отдельный
+// параметр with_tags, который сужает выдачу до работ с каждым из тегов, и
+// число у фасета совпадает с тем, что вернет переход по ссылке. Тег, уже
+// выбранный в любом из параметров, ссылка снимает.
+func tagFacetLinks(r *http.Request, counts []models.FacetCount) []facetLink {
+	query := r.URL.Query()
+	searched := splitParam(query.Get("tags"))
+	refined := splitParam(query.Get("with_tags"))
+
+	links := make([]facetLink, 0, len(counts))
+	for _, fc := range counts {
+		link := facetLink{
+			Label: fc.Value,
+			Count: fc.Count,
+			URL:   toggleParamURL(r, "with_tags", fc.Value),
+		}
+		if containsFold(searched, fc.Value) && !containsFold(refined, fc.Value) {
+			link.URL = toggleParamURL(r, "tags", fc.Value)
+		}
+		link.Active = containsFold(searched, fc.Value) || containsFold(refined, fc.Value)
+		links = append(links, link)
+	}
+	return links
+}
+
+// activeFilterLinks возвращает выбранные значения параметра со ссылками на их снятие
+func activeFilterLinks(r *http.Request, param string) []facetLink {
+	var links []facetLink
+	for _, value := range splitParam(r.URL.Query().Get(param)) {
+		links = append(links, facetLink{
+			Label:  value,
+			URL:    toggleParamURL(r, param, value),
+			Active: true,
+		})
+	}
+	return links
+}
+
+func toggleParamURL(r *http.Request, param, value string) string {
+	query := r.URL.Query()
+	query.Del("cursor")
+	query.Del("page")
+
+	values := splitParam(query.Get(param))
+	if containsFold(values, value) {
+		kept := values[:0]
+		for _, v := range values {
+			if !strings.EqualFold(v, value) {
+				kept = append(kept, v)
+			}
+		}
+		values = kept
+	} else {
+		values = append(values, value)
+	}
+
+	if len(values) > 0 {
+		query.Set(param, strings.Join(values, ","))
+	} else {
+		query.Del(param)
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+func splitParam(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchFilter читает структурные фильтры поиска из адреса страницы
+func searchFilter(r *http.Request) models.SearchFilter {
+	return models.ParseSearchFilter(r.URL.Query())
+}
+
+// labelFacets подставляет человекочитаемые подписи вместо кодов значений
+func labelFacets(links []facetLink, label func(string) string) []facetLink {
+	for i := range links {
+		if l := label(links[i].Label); l != "" {
+			links[i].Label = l
+		}
+	}
+	return links
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sob/pkg/events"
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+// redirectBack возвращает пользователя на адрес из поля next, если это
+// локальный путь, иначе на fallback
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = fallback
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// publish отправляет событие в шину, если она подключена
+func (h *Handler) publish(event events.Event) {
+	if h.Events != nil {
+		h.Events.Publish(event)
+	}
+}
+
+func (h *Handler) FollowUser(w http.ResponseWriter, r *http.Request) {
+	h.setFollow(w, r, true)
+}
+
+func (h *Handler) UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	h.setFollow(w, r, false)
+}
+
+func (h *Handler) setFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	author, err := h.UserRepo.GetByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if follow {
+		err = h.FollowRepo.Follow(int(sess.UserID), author.ID)
+	} else {
+		err = h.FollowRepo.Unfollow(int(sess.UserID), author.ID)
+	}
+	if err == models.ErrSelfFollow {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Follow user error:", err)
+		http.Error(w, "Failed to update follow", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/")
+}
+
+func (h *Handler) SubscribeBook(w http.ResponseWriter, r *http.Request) {
+	h.setSubscription(w, r, true)
+}
+
+func (h *Handler) UnsubscribeBook(w http.ResponseWriter, r *http.Request) {
+	h.setSubscription(w, r, false)
+}
+
+func (h *Handler) setSubscription(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	if book, err := h.BookRepo.GetByID(bookID); err != nil || book == nil || !h.canViewBook(book, int(sess.UserID)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	if subscribe {
+		err = h.FollowRepo.Subscribe(int(sess.UserID), bookID)
+	} else {
+		err = h.FollowRepo.Unsubscribe(int(sess.UserID), bookID)
+	}
+	if err != nil {
+		h.Logger.Error("Subscribe book error:", err)
+		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, fmt.Sprintf("/books/%d", bookID))
+}
+
+func (h *Handler) FollowTag(w http.ResponseWriter, r *http.Request) {
+	h.setTagFollow(w, r, true)
+}
+
+func (h *Handler) UnfollowTag(w http.ResponseWriter, r *http.Request) {
+	h.setTagFollow(w, r, false)
+}
+
+func (h *Handler) setTagFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	name := r.FormValue("tag")
+	if follow {
+		err = h.FollowRepo.FollowTag(int(sess.UserID), name)
+	} else {
+		err = h.FollowRepo.UnfollowTag(int(sess.UserID), name)
+	}
+	if err == models.ErrNoTag {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Follow tag error:", err)
+		http.Error(w, "Failed to update tag follow", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/")
+}
+
+// tagFollowState - тег из фильтра поиска и отметка, следит ли за ним пользователь
+type tagFollowState struct {
+	Name      string
+	Following bool
+}
+
+func (h *Handler) tagFollowStates(userID int, tags []string) []tagFollowState {
+	var states []tagFollowState
+	if len(tags) == 0 {
+		return states
+	}
+
+	followed, err := h.FollowRepo.GetFollowedTags(userID)
+	if err != nil {
+		h.Logger.Error("Get followed tags error:", err)
+		return states
+	}
+	following := make(map[string]bool, len(followed))
+	for _, tag := range followed {
+		following[tag.Normalized] = true
+	}
+
+	for _, name := range tags {
+		norm := models.NormalizeTag(name)
+		if norm == "" {
+			continue
+		}
+		// Синоним считается отслеживаемым, если отслеживается его канонический тег
+		if tag, err := h.TagRepo.GetByName(name); err == nil && tag.MergerID != 0 {
+			if canonical, err := h.TagRepo.GetByID(tag.MergerID); err == nil {
+				norm = canonical.Normalized
+			}
+		}
+		states = append(states, tagFollowState{Name: strings.TrimSpace(name), Following: following[norm]})
+	}
+	return states
+}
@@ -2,19 +2,45 @@ package handlers
 
 import (
 	"html/template"
+	"net/http"
 
+	"sob/pkg/events"
 	"sob/pkg/models"
+	"sob/pkg/notify"
 	"sob/pkg/session"
 
 	"go.uber.org/zap"
 )
 
 type Handler struct {
-	Tmpl      *template.Template
-	Logger    *zap.SugaredLogger
-	UserRepo  *models.UserRepo
-	BookRepo  *models.BookRepo
-	Sessions  *session.SessionsManager
-	UploadDir string
+	Tmpl             *template.Template
+	Logger           *zap.SugaredLogger
+	UserRepo         *models.UserRepo
+	BookRepo         *models.BookRepo
+	TagRepo          *models.TagRepo
+	SavedSearchRepo  *models.SavedSearchRepo
+	CommentRepo      *models.CommentRepo
+	ShelfRepo        *models.ShelfRepo
+	FollowRepo       *models.FollowRepo
+	NotificationRepo *models.NotificationRepo
+	Notifier         *notify.Service
+	Events           *events.Bus
+	MessageRepo      *models.MessageRepo
+	BlockRepo        *models.BlockRepo
+	SeriesRepo       *models.SeriesRepo
+	CoAuthorRepo     *models.CoAuthorRepo
+	RevisionRepo     *models.RevisionRepo
+	Sessions         *session.SessionsManager
+	UploadDir        string
+	// Secret используется для хэширования IP гостей и подписи ссылок
+	Secret string
 }
 
+// sessionUserID возвращает ID вошедшего пользователя или 0 для гостя
+func sessionUserID(r *http.Request) int {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil || sess == nil {
+		return 0
+	}
+	return int(sess.UserID)
+}
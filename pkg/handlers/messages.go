@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+func validateMessageBody(body string) (string, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" || len([]rune(body)) > models.MaxMessageLength {
+		return "", false
+	}
+	return body, true
+}
+
+// messageError переводит ошибки отправки в ответ; возвращает false, если
+// ошибки не было
+func (h *Handler) messageError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return false
+	case models.ErrNoConversation:
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+	case models.ErrSelfMessage:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case models.ErrMessageBlocked:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case models.ErrRateLimited:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		h.Logger.Error("Send message error:", err)
+		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+	}
+	return true
+}
+
+func (h *Handler) InboxPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	conversations, err := h.MessageRepo.Inbox(user.ID)
+	if err != nil {
+		h.Logger.Error("Get inbox error:", err)
+		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+	h.Tmpl.ExecuteTemplate(w, "messages.html", map[string]interface{}{
+		"User":          user,
+		"Conversations": conversations,
+	})
+}
+
+func (h *Handler) NewMessagePage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "message_new.html", map[string]interface{}{
+		"User":  user,
+		"To":    r.URL.Query().Get("to"),
+		"Error": r.URL.Query().Get("error"),
+	})
+}
+
+func (h *Handler) StartConversation(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	body, ok := validateMessageBody(r.FormValue("body"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Message must be 1-%d characters", models.MaxMessageLength), http.StatusBadRequest)
+		return
+	}
+
+	recipient, err := h.UserRepo.GetByUsername(strings.TrimSpace(r.FormValue("to")))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	conversationID, err := h.MessageRepo.Start(int(sess.UserID), recipient.ID, body)
+	if err == models.ErrMessageBlocked || err == models.ErrRateLimited {
+		params := url.Values{"to": {recipient.Username}, "error": {err.Error()}}
+		http.Redirect(w, r, "/messages/new?"+params.Encode(), http.StatusFound)
+		return
+	}
+	if h.messageError(w, err) {
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/messages/%d", conversationID), http.StatusFound)
+}
+
+func (h *Handler) ConversationPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := h.MessageRepo.Get(id, int(sess.UserID))
+	if h.messageError(w, err) {
+		return
+	}
+
+	messages, err := h.MessageRepo.GetMessages(id)
+	if err != nil {
+		h.Logger.Error("Get messages error:", err)
+		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+	if err := h.MessageRepo.MarkRead(id, int(sess.UserID)); err != nil {
+		h.Logger.Error("Mark messages read error:", err)
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	blocked, err := h.BlockRepo.HasBlocked(user.ID, conversation.OtherID)
+	if err != nil {
+		h.Logger.Error("Check block error:", err)
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "conversation.html", map[string]interface{}{
+		"User":         user,
+		"Conversation": conversation,
+		"Messages":     messages,
+		"Blocked":      blocked,
+		"Error":        r.URL.Query().Get("error"),
+	})
+}
+
+func (h *Handler) ReplyMessage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	body, ok := validateMessageBody(r.FormValue("body"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Message must be 1-%d characters", models.MaxMessageLength), http.StatusBadRequest)
+		return
+	}
+
+	// Блокировка и лимит - ожидаемые ситуации, их показываем в самой переписке
+	err = h.MessageRepo.Reply(id, int(sess.UserID), body)
+	if err == models.ErrMessageBlocked || err == models.ErrRateLimited {
+		http.Redirect(w, r, fmt.Sprintf("/messages/%d?error=%s", id, url.QueryEscape(err.Error())), http.StatusFound)
+		return
+	}
+	if h.messageError(w, err) {
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/messages/%d#bottom", id), http.StatusFound)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"sob/pkg/digest"
+	"sob/pkg/models"
+	"sob/pkg/session"
+)
+
+func (h *Handler) NotificationSettingsPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	prefs, err := h.NotificationRepo.GetPreferences(user.ID)
+	if err != nil {
+		h.Logger.Error("Get notification preferences error:", err)
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "notification_settings.html", map[string]interface{}{
+		"User":          user,
+		"Types":         models.NotificationTypes,
+		"Modes":         models.DeliveryModes,
+		"Preferences":   prefs,
+		"SavedSearches": h.savedSearchesWithCounts(user.ID),
+		"Saved":         r.URL.Query().Get("saved") != "",
+	})
+}
+
+func (h *Handler) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	for _, t := range models.NotificationTypes {
+		delivery := r.FormValue("delivery_" + t)
+		if delivery == "" {
+			continue
+		}
+		if err := h.NotificationRepo.SetPreference(int(sess.UserID), t, delivery); err != nil {
+			h.Logger.Error("Set notification preference error:", err)
+			http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/settings/notifications?saved=1", http.StatusFound)
+}
+
+// Unsubscribe отключает письма по подписанной ссылке из письма; вход на
+// сайт не нужен. GET только показывает форму подтверждения: ссылки из писем
+// открывают сканеры почты и предзагрузка, и отписка по GET срабатывала бы
+// без ведома пользователя. Отписывает POST - из этой формы или в один клик
+// из почтового клиента (List-Unsubscribe-Post).
+func (h *Handler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.FormValue("u"))
+	if err != nil {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	notificationType := r.FormValue("type")
+	if !digest.VerifyUnsubscribeToken(h.Secret, userID, notificationType, r.FormValue("token")) {
+		http.Error(w, "Invalid unsubscribe link", http.StatusForbidden)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Type": notificationType,
+	}
+	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		if user, err := h.UserRepo.GetByID(int(sess.UserID)); err == nil {
+			data["User"] = user
+		}
+	}
+
+	if r.Method != http.MethodPost {
+		data["Confirm"] = true
+		data["UserID"] = userID
+		data["Token"] = r.FormValue("token")
+		h.Tmpl.ExecuteTemplate(w, "unsubscribe.html", data)
+		return
+	}
+
+	switch notificationType {
+	case digest.UnsubscribeAll:
+		for _, t := range models.NotificationTypes {
+			if err = h.NotificationRepo.SetPreference(userID, t, models.DeliveryOff); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = h.SavedSearchRepo.DisableEmailDigests(userID)
+		}
+	case digest.UnsubscribeSavedSearches:
+		err = h.SavedSearchRepo.DisableEmailDigests(userID)
+	default:
+		err = h.NotificationRepo.SetPreference(userID, notificationType, models.DeliveryOff)
+	}
+	if err != nil {
+		h.Logger.Error("Unsubscribe error:", err)
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "unsubscribe.html", data)
+}
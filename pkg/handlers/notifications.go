@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) NotificationsPage(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get user by ID error:", err)
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	beforeID, _ := strconv.Atoi(r.URL.Query().Get("before"))
+
+	// Запрашиваем на одно уведомление больше, чтобы понять, есть ли старше
+	notifications, err := h.NotificationRepo.GetByUserID(user.ID, beforeID, models.NotificationsPageSize+1)
+	if err != nil {
+		h.Logger.Error("Get notifications error:", err)
+		notifications = []*models.Notification{}
+	}
+
+	data := map[string]interface{}{
+		"User": user,
+	}
+	if len(notifications) > models.NotificationsPageSize {
+		notifications = notifications[:models.NotificationsPageSize]
+		data["OlderURL"] = "/notifications?before=" + strconv.Itoa(notifications[len(notifications)-1].ID)
+	}
+	data["Notifications"] = notifications
+	data["IsFirstPage"] = beforeID == 0
+
+	h.Tmpl.ExecuteTemplate(w, "notifications.html", data)
+}
+
+// OpenNotification отмечает уведомление прочитанным и переходит по его ссылке
+func (h *Handler) OpenNotification(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	n, err := h.NotificationRepo.GetByID(id, int(sess.UserID))
+	if err != nil {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.NotificationRepo.MarkRead(id, int(sess.UserID)); err != nil {
+		h.Logger.Error("Mark notification read error:", err)
+	}
+
+	link := n.Link
+	if link == "" {
+		link = "/notifications"
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
+
+func (h *Handler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.NotificationRepo.MarkRead(id, int(sess.UserID)); err != nil {
+		h.Logger.Error("Mark notification read error:", err)
+		http.Error(w, "Failed to mark notification", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/notifications")
+}
+
+func (h *Handler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err := h.NotificationRepo.MarkAllRead(int(sess.UserID)); err != nil {
+		h.Logger.Error("Mark all notifications read error:", err)
+		http.Error(w, "Failed to mark notifications", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
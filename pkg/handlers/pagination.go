@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"sob/pkg/models"
+)
+
+func pageRequest(r *http.Request) models.PageRequest {
+	return models.PageRequest{
+		Cursor: r.URL.Query().Get("cursor"),
+		Limit:  models.DefaultPageSize,
+	}
+}
+
+// addPagination кладет в данные шаблона номер страницы и ссылки на соседние
+// страницы, сохраняя остальные параметры запроса (q, tags, sort)
+func addPagination(data map[string]interface{}, r *http.Request, page *models.BookPage) {
+	current, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || current < 1 {
+		current = 1
+	}
+	data["Page"] = current
+
+	if page.NextCursor != "" {
+		data["NextURL"] = pageURL(r, page.NextCursor, current+1)
+	}
+	if page.PrevCursor != "" {
+		data["PrevURL"] = pageURL(r, page.PrevCursor, current-1)
+	}
+}
+
+func pageURL(r *http.Request, cursor string, page int) string {
+	query := r.URL.Query()
+	query.Del("cursor")
+	query.Del("page")
+	// Вторая страница назад - это просто первая страница без курсора
+	if page > 1 {
+		query.Set("cursor", cursor)
+		query.Set("page", strconv.Itoa(page))
+	}
+
+	if encoded := query.Encode(); encoded != "" {
+		return r.URL.Path + "?" + encoded
+	}
+	return r.URL.Path
+}
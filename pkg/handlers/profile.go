@@ -6,10 +6,105 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
+	"sob/pkg/models"
 	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
 )
 
+// UserProfile - публичная страница автора: описание, статистика, работы
+// и публичные полки
+func (h *Handler) UserProfile(w http.ResponseWriter, r *http.Request) {
+	author, err := h.UserRepo.GetByUsername(mux.Vars(r)["username"])
+	if err == models.ErrNoUser {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Get user by username error:", err)
+		http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	author.Password = ""
+
+	// Заблокированный пользователь не видит профиль заблокировавшего
+	viewerID := sessionUserID(r)
+	if viewerID != 0 {
+		blocked, err := h.BlockRepo.HasBlocked(author.ID, viewerID)
+		if err != nil {
+			h.Logger.Error("Check block error:", err)
+		}
+		if blocked {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	page, err := h.BookRepo.GetByUserID(viewerID, author.ID, pageRequest(r))
+	if err != nil {
+		h.Logger.Error("Get user books error:", err)
+		page = &models.BookPage{Books: []*models.Book{}}
+	}
+
+	stats, err := h.BookRepo.GetAuthorStats(viewerID, author.ID)
+	if err != nil {
+		h.Logger.Error("Get author stats error:", err)
+		stats = &models.AuthorStats{}
+	}
+	followerCount, err := h.FollowRepo.CountFollowers(author.ID)
+	if err != nil {
+		h.Logger.Error("Count followers error:", err)
+	}
+	shelves, err := h.ShelfRepo.GetByUserID(author.ID, false)
+	if err != nil {
+		h.Logger.Error("Get shelves error:", err)
+	}
+	series, err := h.SeriesRepo.GetByUserID(author.ID, viewerID)
+	if err != nil {
+		h.Logger.Error("Get series error:", err)
+	}
+
+	data := map[string]interface{}{
+		"Author":        author,
+		"Books":         page.Books,
+		"Stats":         stats,
+		"FollowerCount": followerCount,
+		"Shelves":       shelves,
+		"Series":        series,
+	}
+	addPagination(data, r, page)
+
+	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		user, err := h.UserRepo.GetByID(int(sess.UserID))
+		if err != nil {
+			h.Logger.Error("Get user by ID error:", err)
+		} else {
+			data["User"] = user
+			data["IsSelf"] = user.ID == author.ID
+			following, err := h.FollowRepo.IsFollowing(user.ID, author.ID)
+			if err != nil {
+				h.Logger.Error("Check follow error:", err)
+			}
+			data["IsFollowing"] = following
+			blocked, err := h.BlockRepo.HasBlocked(user.ID, author.ID)
+			if err != nil {
+				h.Logger.Error("Check block error:", err)
+			}
+			data["IsBlocked"] = blocked
+			muted, err := h.BlockRepo.HasMuted(user.ID, author.ID)
+			if err != nil {
+				h.Logger.Error("Check mute error:", err)
+			}
+			data["IsMuted"] = muted
+		}
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "user_profile.html", data)
+}
+
 func (h *Handler) EditProfilePage(w http.ResponseWriter, r *http.Request) {
 	sess, err := session.SessionFromContext(r.Context())
 	if err != nil {
@@ -102,6 +197,31 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Описание профиля не требует пароля, как и аватарка
+	bio := strings.TrimSpace(r.FormValue("bio"))
+	if utf8.RuneCountInString(bio) > models.MaxBioLength {
+		http.Error(w, "Bio is too long", http.StatusBadRequest)
+		return
+	}
+	if bio != currentUser.Bio {
+		err = h.UserRepo.UpdateBio(userID, bio)
+		if err != nil {
+			h.Logger.Error("Update bio error:", err)
+			http.Error(w, "Failed to update bio", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	viewAdult := r.FormValue("view_adult") != ""
+	if viewAdult != currentUser.ViewAdult {
+		err = h.UserRepo.SetViewAdult(userID, viewAdult)
+		if err != nil {
+			h.Logger.Error("Update view adult error:", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Обрабатываем загрузку аватарки
 	avatarFile, avatarHeader, err := r.FormFile("avatar")
 	if err == nil {
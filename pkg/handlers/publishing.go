@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"sob/pkg/events"
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+// Способы публикации при загрузке работы
+const (
+	publishNow      = "now"
+	publishDraft    = "draft"
+	publishSchedule = "schedule"
+)
+
+func redirectToPublishing(w http.ResponseWriter, r *http.Request, bookID int, errMsg string) {
+	target := fmt.Sprintf("/books/%d", bookID)
+	if errMsg != "" {
+		target += "?publish_error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target+"#publishing", http.StatusFound)
+}
+
+// canViewBook проверяет уровень видимости работы и черновик для зрителя
+func (h *Handler) canViewBook(book *models.Book, userID int) bool {
+	canView, err := h.BookRepo.CanView(book, userID)
+	if err != nil {
+		h.Logger.Error("Check view permission error:", err)
+		return false
+	}
+	return canView
+}
+
+// publishTimeForm читает время публикации из поля datetime-local. Браузер
+// присылает местное время без зоны, поэтому форма передает и смещение
+// tz_offset в минутах, как его возвращает getTimezoneOffset.
+func publishTimeForm(r *http.Request) (time.Time, error) {
+	local, err := time.Parse("2006-01-02T15:04", r.FormValue("publish_at"))
+	if err != nil {
+		return time.Time{}, models.ErrBadPublishTime
+	}
+	offset, _ := strconv.Atoi(r.FormValue("tz_offset"))
+	at := local.Add(time.Duration(offset) * time.Minute)
+	if !at.After(time.Now()) {
+		return time.Time{}, models.ErrPublishInPast
+	}
+	return at, nil
+}
+
+// publishForm читает, как опубликовать новую работу: сразу, черновиком
+// или по расписанию
+func publishForm(r *http.Request) (draft bool, publishedAt string, err error) {
+	switch r.FormValue("publish_mode") {
+	case "", publishNow:
+		return false, "", nil
+	case publishDraft:
+		return true, "", nil
+	case publishSchedule:
+		at, err := publishTimeForm(r)
+		if err != nil {
+			return false, "", err
+		}
+		return true, at.UTC().Format(models.TimeLayout), nil
+	}
+	return false, "", models.ErrBadPublishTime
+}
+
+// editableBook загружает работу из URL и проверяет, что текущий
+// пользователь может ее редактировать
+func (h *Handler) editableBook(w http.ResponseWriter, r *http.Request) (*models.Book, bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return nil, false
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	book, err := h.BookRepo.GetByID(bookID)
+	if err != nil || book == nil {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !h.canEditBook(book, int(sess.UserID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return book, true
+}
+
+// PublishBook публикует черновик сразу и оповещает подписчиков автора
+func (h *Handler) PublishBook(w http.ResponseWriter, r *http.Request) {
+	book, ok := h.editableBook(w, r)
+	if !ok {
+		return
+	}
+
+	published, err := h.BookRepo.Publish(book.ID)
+	if err != nil {
+		h.Logger.Error("Publish book error:", err)
+		http.Error(w, "Failed to publish work", http.StatusInternalServerError)
+		return
+	}
+	if published {
+		book.Draft = false
+		if book.Notifiable() {
+			h.publish(events.Event{
+				Type:      events.BookPublished,
+				ActorID:   book.UserID,
+				BookID:    book.ID,
+				BookTitle: book.Title,
+			})
+		}
+	}
+
+	redirectToPublishing(w, r, book.ID, "")
+}
+
+// ScheduleBook назначает черновику время публикации или снимает его,
+// если поле времени пустое
+func (h *Handler) ScheduleBook(w http.ResponseWriter, r *http.Request) {
+	book, ok := h.editableBook(w, r)
+	if !ok {
+		return
+	}
+	if !book.IsDraft() {
+		redirectToPublishing(w, r, book.ID, "the work is already published")
+		return
+	}
+
+	var at time.Time
+	if r.FormValue("publish_at") != "" {
+		var err error
+		if at, err = publishTimeForm(r); err != nil {
+			redirectToPublishing(w, r, book.ID, err.Error())
+			return
+		}
+	}
+
+	err := h.BookRepo.Schedule(book.ID, at)
+	if err == models.ErrPublishInPast {
+		redirectToPublishing(w, r, book.ID, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Schedule book error:", err)
+		http.Error(w, "Failed to schedule work", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToPublishing(w, r, book.ID, "")
+}
+
+// UnpublishBook возвращает работу в черновики
+func (h *Handler) UnpublishBook(w http.ResponseWriter, r *http.Request) {
+	book, ok := h.editableBook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.BookRepo.Unpublish(book.ID); err != nil {
+		h.Logger.Error("Unpublish book error:", err)
+		http.Error(w, "Failed to unpublish work", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToPublishing(w, r, book.ID, "")
+}
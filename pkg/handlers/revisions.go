@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"sob/pkg/events"
+	"sob/pkg/models"
+	"sob/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// diffContext - сколько символов без изменений показывать вокруг правки
+const diffContext = 200
+
+// saveRevision сохраняет новый текст работы версией и записывает его в
+// файл. Перед первой записью текущий файл сохраняется исходной версией.
+// Файл перезаписывается только после того, как версия сохранена: иначе
+// прежний текст пропал бы без следа в истории.
+func (h *Handler) saveRevision(book *models.Book, userID int, content []byte, note string) error {
+	if old, err := os.ReadFile(book.FilePath); err == nil {
+		if err := h.RevisionRepo.EnsureBaseline(book, old); err != nil {
+			return fmt.Errorf("save original version: %v", err)
+		}
+	}
+
+	rev, err := h.RevisionRepo.Add(book.ID, userID, content, note)
+	if err != nil {
+		return fmt.Errorf("add revision: %v", err)
+	}
+	if err := os.WriteFile(book.FilePath, content, 0644); err != nil {
+		// Версия, текст которой не попал в файл, не должна числиться последней
+		if rev != nil {
+			if err := h.RevisionRepo.Delete(rev.ID); err != nil {
+				h.Logger.Error("Delete revision error:", err)
+			}
+		}
+		return err
+	}
+	h.updateTextStats(book.ID, book.FilePath)
+	return nil
+}
+
+// normalizeNewlines приводит переводы строк к \n: браузер присылает текст
+// из формы с \r\n, и без этого первая правка меняла бы каждую строку
+func normalizeNewlines(content []byte) string {
+	return strings.ReplaceAll(string(content), "\r\n", "\n")
+}
+
+// BookHistory показывает версии работы и сравнение двух из них: по
+// умолчанию последней с предыдущей
+func (h *Handler) BookHistory(w http.ResponseWriter, r *http.Request) {
+	book, ok := h.editableBook(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Book":     book,
+		"TextFile": utils.IsTextFile(book.Filename),
+	}
+	if user, err := h.UserRepo.GetByID(sessionUserID(r)); err == nil {
+		data["User"] = user
+	}
+
+	if !utils.IsTextFile(book.Filename) {
+		h.Tmpl.ExecuteTemplate(w, "history.html", data)
+		return
+	}
+
+	// Работы, загруженные до истории версий, получают исходную версию
+	// при первом открытии истории
+	if content, err := os.ReadFile(book.FilePath); err == nil {
+		if err := h.RevisionRepo.EnsureBaseline(book, content); err != nil {
+			h.Logger.Error("Save original version error:", err)
+		}
+	}
+
+	revisions, err := h.RevisionRepo.GetByBook(book.ID)
+	if err != nil {
+		h.Logger.Error("Get revisions error:", err)
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	data["Revisions"] = revisions
+	if len(revisions) > 0 {
+		data["Latest"] = revisions[0].Number
+	}
+
+	if len(revisions) > 1 {
+		to, _ := strconv.Atoi(r.URL.Query().Get("to"))
+		if to <= 0 {
+			to = revisions[0].Number
+		}
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		if from <= 0 {
+			from = to - 1
+		}
+		if from < 1 {
+			from = 1
+		}
+
+		fromRev, fromContent, err := h.RevisionRepo.Get(book.ID, from)
+		if err == nil {
+			var toRev *models.Revision
+			var toContent []byte
+			toRev, toContent, err = h.RevisionRepo.Get(book.ID, to)
+			if err == nil {
+				ops := utils.WordDiff(normalizeNewlines(fromContent), normalizeNewlines(toContent))
+				inserted, deleted := utils.DiffStats(ops)
+				data["From"] = fromRev
+				data["To"] = toRev
+				data["Diff"] = utils.CollapseDiff(ops, diffContext)
+				data["Inserted"] = inserted
+				data["Deleted"] = deleted
+			}
+		}
+		if err == models.ErrNoRevision {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Get revision error:", err)
+			http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "history.html", data)
+}
+
+// RestoreRevision возвращает работе текст старой версии. Восстановление
+// сохраняется новой версией, так что его тоже можно отменить.
+func (h *Handler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	book, ok := h.editableBook(w, r)
+	if !ok {
+		return
+	}
+
+	number, err := strconv.Atoi(mux.Vars(r)["number"])
+	if err != nil {
+		http.Error(w, "Invalid revision number", http.StatusBadRequest)
+		return
+	}
+
+	_, content, err := h.RevisionRepo.Get(book.ID, number)
+	if err == models.ErrNoRevision {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Get revision error:", err)
+		http.Error(w, "Failed to load revision", http.StatusInternalServerError)
+		return
+	}
+
+	userID := sessionUserID(r)
+	note := fmt.Sprintf("Restored revision #%d", number)
+	if err := h.saveRevision(book, userID, content, note); err != nil {
+		h.Logger.Error("Restore revision error:", err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.BookRepo.DB.Exec("UPDATE books SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", book.ID)
+	if err != nil {
+		h.Logger.Error("Update book record error:", err)
+	}
+
+	// Видимость и черновик могли измениться, пока открыта история, поэтому
+	// правило оповещения проверяем по свежей записи
+	current, err := h.BookRepo.GetByID(book.ID)
+	if err != nil {
+		h.Logger.Error("Get book error:", err)
+	} else if current != nil && current.Notifiable() {
+		h.publish(events.Event{
+			Type:      events.BookUpdated,
+			ActorID:   userID,
+			BookID:    current.ID,
+			BookTitle: current.Title,
+		})
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d/history", book.ID), http.StatusFound)
+}
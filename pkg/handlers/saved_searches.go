@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) SaveSearch(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	search := &models.SavedSearch{
+		UserID:      int(sess.UserID),
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Query:       r.FormValue("q"),
+		Tags:        r.FormValue("tags"),
+		SortBy:      r.FormValue("sort"),
+		EmailDigest: r.FormValue("email_digest") == "on",
+	}
+	// Фильтры приходят строкой запроса страницы поиска; неизвестные
+	// значения отбрасываем, как и в самом поиске
+	if params, err := url.ParseQuery(r.FormValue("filter_params")); err == nil {
+		search.FilterParams = models.ParseSearchFilter(params).Values().Encode()
+	}
+	if search.Name == "" {
+		search.Name = search.Query
+		if search.Name == "" {
+			search.Name = search.Tags
+		}
+	}
+	if search.Name == "" {
+		http.Error(w, "Search name is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.SavedSearchRepo.Create(search)
+	if err != nil {
+		h.Logger.Error("Save search error:", err)
+		http.Error(w, "Failed to save search", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusFound)
+}
+
+// OpenSavedSearch отмечает новые результаты просмотренными и открывает поиск
+func (h *Handler) OpenSavedSearch(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid search ID", http.StatusBadRequest)
+		return
+	}
+
+	search, err := h.SavedSearchRepo.GetByID(id, int(sess.UserID))
+	if err != nil {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.SavedSearchRepo.MarkSeen(id, int(sess.UserID)); err != nil {
+		h.Logger.Error("Mark saved search seen error:", err)
+	}
+
+	http.Redirect(w, r, search.URL(), http.StatusFound)
+}
+
+func (h *Handler) UpdateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid search ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.SavedSearchRepo.SetEmailDigest(id, int(sess.UserID), r.FormValue("email_digest") == "on")
+	if err != nil {
+		h.Logger.Error("Update saved search error:", err)
+		http.Error(w, "Failed to update saved search", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusFound)
+}
+
+func (h *Handler) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid search ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.SavedSearchRepo.Delete(id, int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Delete saved search error:", err)
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusFound)
+}
+
+// savedSearchesWithCounts загружает сохраненные поиски пользователя вместе
+// с числом новых книг с последнего просмотра
+func (h *Handler) savedSearchesWithCounts(userID int) []*models.SavedSearch {
+	searches, err := h.SavedSearchRepo.GetByUserID(userID)
+	if err != nil {
+		h.Logger.Error("Get saved searches error:", err)
+		return nil
+	}
+
+	for _, search := range searches {
+		search.NewCount, err = h.BookRepo.CountNewMatches(userID, search.Query, splitParam(search.Tags), search.Filter(), search.LastSeenAt)
+		if err != nil {
+			h.Logger.Error("Count saved search matches error:", err)
+		}
+	}
+	return searches
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxSeriesTitleLength       = 200
+	maxSeriesDescriptionLength = 5000
+	// maxSeriesCandidates - сколько своих работ предлагается для добавления в цикл
+	maxSeriesCandidates = 500
+)
+
+func redirectToSeries(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/profile?tab=series"
+	if errMsg != "" {
+		target += "&error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// seriesForm читает и проверяет название и описание цикла
+func seriesForm(r *http.Request) (title, description, errMsg string) {
+	title = strings.TrimSpace(r.FormValue("title"))
+	description = strings.TrimSpace(r.FormValue("description"))
+	if len([]rune(title)) > maxSeriesTitleLength {
+		return "", "", "SERIES_TITLE_TOO_LONG"
+	}
+	if len([]rune(description)) > maxSeriesDescriptionLength {
+		return "", "", "SERIES_DESCRIPTION_TOO_LONG"
+	}
+	return title, description, ""
+}
+
+// SeriesPage показывает части цикла в порядке чтения
+func (h *Handler) SeriesPage(w http.ResponseWriter, r *http.Request) {
+	seriesID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.SeriesRepo.GetByID(seriesID, sessionUserID(r))
+	if err != nil {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+
+	series.Books, err = h.SeriesRepo.GetBooks(series.ID, sessionUserID(r))
+	if err != nil {
+		h.Logger.Error("Get series books error:", err)
+		series.Books = []*models.Book{}
+	}
+
+	data := map[string]interface{}{
+		"Series": series,
+	}
+	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		data["IsOwner"] = int(sess.UserID) == series.UserID
+		if user, err := h.UserRepo.GetByID(int(sess.UserID)); err == nil {
+			data["User"] = user
+		}
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "series.html", data)
+}
+
+// loadProfileSeries заполняет вкладку циклов в профиле: циклы с частями и
+// работы автора, которые можно в них добавить
+func (h *Handler) loadProfileSeries(data map[string]interface{}, userID int) {
+	list, err := h.SeriesRepo.GetByUserID(userID, userID)
+	if err != nil {
+		h.Logger.Error("Get series error:", err)
+	}
+	for _, series := range list {
+		series.Books, err = h.SeriesRepo.GetBooks(series.ID, userID)
+		if err != nil {
+			h.Logger.Error("Get series books error:", err)
+		}
+	}
+	data["SeriesList"] = list
+
+	page, err := h.BookRepo.GetByUserID(userID, userID, models.PageRequest{Limit: maxSeriesCandidates})
+	if err != nil {
+		h.Logger.Error("Get user books error:", err)
+		return
+	}
+	// В цикл можно добавить только свои работы, без совместных
+	var own []*models.Book
+	for _, book := range page.Books {
+		if book.UserID == userID {
+			own = append(own, book)
+		}
+	}
+	data["OwnBooks"] = own
+}
+
+func (h *Handler) CreateSeries(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	title, description, errMsg := seriesForm(r)
+	if errMsg != "" {
+		redirectToSeries(w, r, errMsg)
+		return
+	}
+
+	_, err = h.SeriesRepo.Create(int(sess.UserID), title, description)
+	if err == models.ErrInvalidSeriesTitle {
+		redirectToSeries(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Create series error:", err)
+		http.Error(w, "Failed to create series", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToSeries(w, r, "")
+}
+
+func (h *Handler) UpdateSeries(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	seriesID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+
+	title, description, errMsg := seriesForm(r)
+	if errMsg != "" {
+		redirectToSeries(w, r, errMsg)
+		return
+	}
+
+	err = h.SeriesRepo.Update(seriesID, int(sess.UserID), title, description)
+	if err == models.ErrNoSeries {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrInvalidSeriesTitle {
+		redirectToSeries(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Update series error:", err)
+		http.Error(w, "Failed to update series", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToSeries(w, r, "")
+}
+
+func (h *Handler) DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	seriesID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.SeriesRepo.Delete(seriesID, int(sess.UserID))
+	if err == models.ErrNoSeries {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Delete series error:", err)
+		http.Error(w, "Failed to delete series", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToSeries(w, r, "")
+}
+
+// UpdateSeriesParts добавляет, убирает или переставляет часть цикла:
+// action - add, remove, up или down, book_id - работа
+func (h *Handler) UpdateSeriesParts(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	seriesID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid series ID", http.StatusBadRequest)
+		return
+	}
+
+	bookID, err := strconv.Atoi(r.FormValue("book_id"))
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := int(sess.UserID)
+	switch r.FormValue("action") {
+	case "add":
+		err = h.SeriesRepo.AddBook(seriesID, userID, bookID)
+	case "remove":
+		err = h.SeriesRepo.RemoveBook(seriesID, userID, bookID)
+	case "up":
+		err = h.SeriesRepo.MoveBook(seriesID, userID, bookID, -1)
+	case "down":
+		err = h.SeriesRepo.MoveBook(seriesID, userID, bookID, 1)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if err == models.ErrNoSeries {
+		http.Error(w, "Series not found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrNotOwnWork {
+		redirectToSeries(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Update series parts error:", err)
+		http.Error(w, "Failed to update series", http.StatusInternalServerError)
+		return
+	}
+
+	redirectBack(w, r, "/profile?tab=series")
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+const maxShelfNoteLength = 2000
+
+func redirectToShelves(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/profile?tab=shelves"
+	if errMsg != "" {
+		target += "&error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// ShelfPage показывает книги полки. Приватные полки доступны только
+// владельцу, заметки к книгам тоже видит только он.
+func (h *Handler) ShelfPage(w http.ResponseWriter, r *http.Request) {
+	shelfID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid shelf ID", http.StatusBadRequest)
+		return
+	}
+
+	shelf, err := h.ShelfRepo.GetByID(shelfID)
+	if err != nil {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Shelf": shelf,
+	}
+
+	isOwner := false
+	if sess, err := session.SessionFromContext(r.Context()); err == nil {
+		isOwner = int(sess.UserID) == shelf.UserID
+		if user, err := h.UserRepo.GetByID(int(sess.UserID)); err == nil {
+			data["User"] = user
+		}
+	}
+
+	if !shelf.IsPublic() && !isOwner {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+
+	items, err := h.ShelfRepo.GetItems(shelf.ID, sessionUserID(r))
+	if err != nil {
+		h.Logger.Error("Get shelf items error:", err)
+		items = []*models.ShelfItem{}
+	}
+	if !isOwner {
+		for _, item := range items {
+			item.Note = ""
+		}
+	}
+
+	data["Items"] = items
+	data["IsOwner"] = isOwner
+
+	h.Tmpl.ExecuteTemplate(w, "shelf.html", data)
+}
+
+func (h *Handler) CreateShelf(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if len(name) > 100 {
+		redirectToShelves(w, r, "SHELF_NAME_TOO_LONG")
+		return
+	}
+
+	_, err = h.ShelfRepo.Create(int(sess.UserID), name, r.FormValue("privacy"))
+	if err == models.ErrInvalidShelfName || err == models.ErrShelfNameTaken {
+		redirectToShelves(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Create shelf error:", err)
+		http.Error(w, "Failed to create shelf", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToShelves(w, r, "")
+}
+
+func (h *Handler) UpdateShelf(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	shelfID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid shelf ID", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if len(name) > 100 {
+		redirectToShelves(w, r, "SHELF_NAME_TOO_LONG")
+		return
+	}
+
+	err = h.ShelfRepo.Update(shelfID, int(sess.UserID), name, r.FormValue("privacy"))
+	if err == models.ErrNoShelf {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrShelfNameTaken {
+		redirectToShelves(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Update shelf error:", err)
+		http.Error(w, "Failed to update shelf", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToShelves(w, r, "")
+}
+
+func (h *Handler) DeleteShelf(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	shelfID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid shelf ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.ShelfRepo.Delete(shelfID, int(sess.UserID))
+	if err == models.ErrNoShelf {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+	if err == models.ErrDefaultShelf {
+		redirectToShelves(w, r, err.Error())
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Delete shelf error:", err)
+		http.Error(w, "Failed to delete shelf", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToShelves(w, r, "")
+}
+
+// ShelveBook кладет книгу на полку; повторный вызов обновляет заметку
+func (h *Handler) ShelveBook(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	if book, err := h.BookRepo.GetByID(bookID); err != nil || book == nil || !h.canViewBook(book, int(sess.UserID)) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	shelfID, err := strconv.Atoi(r.FormValue("shelf_id"))
+	if err != nil {
+		http.Error(w, "Invalid shelf ID", http.StatusBadRequest)
+		return
+	}
+
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len([]rune(note)) > maxShelfNoteLength {
+		http.Error(w, "Note is too long", http.StatusBadRequest)
+		return
+	}
+
+	err = h.ShelfRepo.AddBook(shelfID, int(sess.UserID), bookID, note)
+	if err == models.ErrNoShelf {
+		http.Error(w, "Shelf not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Shelve book error:", err)
+		http.Error(w, "Failed to add book to shelf", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d#shelves", bookID), http.StatusFound)
+}
+
+func (h *Handler) UnshelveBook(w http.ResponseWriter, r *http.Request) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+
+	shelfID, err := strconv.Atoi(r.FormValue("shelf_id"))
+	if err != nil {
+		http.Error(w, "Invalid shelf ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ShelfRepo.RemoveBook(shelfID, int(sess.UserID), bookID); err != nil {
+		h.Logger.Error("Unshelve book error:", err)
+		http.Error(w, "Failed to remove book from shelf", http.StatusInternalServerError)
+		return
+	}
+
+	// Со страницы полки возвращаемся на нее же
+	if r.FormValue("return") == "shelf" {
+		http.Redirect(w, r, fmt.Sprintf("/shelves/%d", shelfID), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/books/%d#shelves", bookID), http.StatusFound)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+	"sob/pkg/session"
+
+	"github.com/gorilla/mux"
+)
+
+// requireModerator возвращает текущего пользователя, если он модератор,
+// иначе сам отвечает ошибкой
+func (h *Handler) requireModerator(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	sess, err := session.SessionFromContext(r.Context())
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return nil, false
+	}
+
+	user, err := h.UserRepo.GetByID(int(sess.UserID))
+	if err != nil || !user.IsModerator() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+func redirectToTags(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/tags"
+	if errMsg != "" {
+		target += "?error=" + url.QueryEscape(errMsg)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (h *Handler) TagsPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	tags, err := h.TagRepo.List(query)
+	if err != nil {
+		h.Logger.Error("List tags error:", err)
+		tags = []*models.Tag{}
+	}
+
+	moderators, err := h.UserRepo.GetModerators()
+	if err != nil {
+		h.Logger.Error("Get moderators error:", err)
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "tags.html", map[string]interface{}{
+		"User":       user,
+		"Moderators": moderators,
+		"Tags":       tags,
+		"Query":      query,
+		"Categories": models.TagCategories,
+		"Error":      r.URL.Query().Get("error"),
+	})
+}
+
+// MergeTags делает тег synonym синонимом тега canonical
+func (h *Handler) MergeTags(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	synonym, err := h.TagRepo.GetByName(r.FormValue("synonym"))
+	if err != nil {
+		redirectToTags(w, r, "SYNONYM_TAG_NOT_FOUND")
+		return
+	}
+
+	// Канонический тег можно создать прямо при слиянии
+	canonicalID, err := h.TagRepo.FindOrCreate(r.FormValue("canonical"))
+	if err != nil {
+		redirectToTags(w, r, "CANONICAL_TAG_REQUIRED")
+		return
+	}
+
+	err = h.TagRepo.Merge(synonym.ID, canonicalID)
+	if err != nil {
+		h.Logger.Error("Merge tags error:", err)
+		redirectToTags(w, r, err.Error())
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+// GrantModerator назначает пользователя модератором по имени
+func (h *Handler) GrantModerator(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	err := h.UserRepo.SetRole(strings.TrimSpace(r.FormValue("username")), models.RoleModerator)
+	if err == models.ErrNoUser {
+		redirectToTags(w, r, "USER_NOT_FOUND")
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Grant moderator error:", err)
+		http.Error(w, "Failed to grant moderator role", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+// RevokeModerator снимает роль модератора. Себя снять нельзя, чтобы на
+// сайте не остаться без модераторов.
+func (h *Handler) RevokeModerator(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if userID == user.ID {
+		redirectToTags(w, r, "CANNOT_REVOKE_YOURSELF")
+		return
+	}
+
+	if err := h.UserRepo.RevokeModerator(userID); err != nil {
+		h.Logger.Error("Revoke moderator error:", err)
+		http.Error(w, "Failed to revoke moderator role", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+func (h *Handler) UpdateTag(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	tagID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.TagRepo.Update(tagID, r.FormValue("category"), r.FormValue("canonical") == "on")
+	if err != nil {
+		h.Logger.Error("Update tag error:", err)
+		http.Error(w, "Failed to update tag", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+func (h *Handler) UnmergeTag(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	tagID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.TagRepo.Unmerge(tagID); err != nil {
+		h.Logger.Error("Unmerge tag error:", err)
+		http.Error(w, "Failed to unmerge tag", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+// AddTagParent привязывает тег к родительскому, например персонажа к фандому
+func (h *Handler) AddTagParent(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	tagID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	parentID, err := h.TagRepo.FindOrCreate(r.FormValue("parent"))
+	if err != nil {
+		redirectToTags(w, r, "PARENT_TAG_REQUIRED")
+		return
+	}
+
+	// Родителем всегда выступает канонический тег
+	if parent, err := h.TagRepo.GetByID(parentID); err == nil && parent.MergerID != 0 {
+		parentID = parent.MergerID
+	}
+
+	if err := h.TagRepo.AddParent(tagID, parentID); err != nil {
+		h.Logger.Error("Add tag parent error:", err)
+		redirectToTags(w, r, err.Error())
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+func (h *Handler) RemoveTagParent(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	tagID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+	parentID, err := strconv.Atoi(vars["parentID"])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.TagRepo.RemoveParent(tagID, parentID); err != nil {
+		h.Logger.Error("Remove tag parent error:", err)
+		http.Error(w, "Failed to remove parent", http.StatusInternalServerError)
+		return
+	}
+
+	redirectToTags(w, r, "")
+}
+
+// TagAutocomplete отдает подсказки тегов для форм загрузки и редактирования
+func (h *Handler) TagAutocomplete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	category := r.URL.Query().Get("category")
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 20 {
+		limit = 10
+	}
+
+	tags, err := h.TagRepo.Autocomplete(query, category, limit)
+	if err != nil {
+		h.Logger.Error("Tag autocomplete error:", err)
+		http.Error(w, "Autocomplete failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
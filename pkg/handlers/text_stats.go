@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"sob/pkg/models"
+	"sob/pkg/utils"
+)
+
+// analyzeBookFile заполняет статистику книги по тексту ее файла. Ошибки
+// только логируются: работа остается доступной и без статистики.
+func (h *Handler) analyzeBookFile(book *models.Book) bool {
+	stats, err := utils.AnalyzeFile(book.FilePath)
+	if err != nil {
+		h.Logger.Error("Analyze book text error:", err)
+		return false
+	}
+	book.WordCount = stats.Words
+	book.ChapterCount = stats.Chapters
+	book.CharCount = stats.Characters
+	book.DialogueRatio = stats.DialogueRatio
+	book.AvgSentenceLength = stats.AvgSentenceLength
+	return true
+}
+
+// updateTextStats пересчитывает и сохраняет статистику по файлу книги
+func (h *Handler) updateTextStats(bookID int, filePath string) {
+	book := &models.Book{ID: bookID, FilePath: filePath}
+	if !h.analyzeBookFile(book) {
+		return
+	}
+	if err := h.BookRepo.UpdateTextStats(book); err != nil {
+		h.Logger.Error("Update text stats error:", err)
+	}
+}
+
+// BackfillTextStats считает статистику для книг, загруженных до ее появления
+func (h *Handler) BackfillTextStats() {
+	files, err := h.BookRepo.WithoutTextStats()
+	if err != nil {
+		h.Logger.Error("Get books without text stats error:", err)
+		return
+	}
+	for bookID, filePath := range files {
+		h.updateTextStats(bookID, filePath)
+	}
+	if len(files) > 0 {
+		h.Logger.Infof("Text stats computed for %d books", len(files))
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sob/pkg/models"
+)
+
+// languageForm читает язык работы; по умолчанию - DefaultLanguage
+func languageForm(r *http.Request) (string, error) {
+	language := r.FormValue("language")
+	if language == "" {
+		return models.DefaultLanguage, nil
+	}
+	if models.LanguageLabel(language) == "" {
+		return "", models.ErrBadLanguage
+	}
+	return language, nil
+}
+
+// translationForm читает сведения об оригинале. Если флажок is_translation
+// не отмечен, работа не перевод и возвращается nil. Оригинал на сайте
+// проверяется здесь, до любых записей, чтобы ошибка в ссылке не оставила
+// работу сохраненной наполовину. bookID - редактируемая работа или 0 для
+// новой: работа не может быть переводом самой себя.
+func (h *Handler) translationForm(r *http.Request, bookID int) (*models.Translation, error) {
+	if r.FormValue("is_translation") == "" {
+		return nil, nil
+	}
+
+	t := &models.Translation{
+		OriginalTitle:  r.FormValue("original_title"),
+		OriginalAuthor: r.FormValue("original_author"),
+		OriginalURL:    strings.TrimSpace(r.FormValue("original_url")),
+		Permission:     r.FormValue("permission"),
+	}
+	if t.Permission == "" {
+		t.Permission = models.PermissionNotAsked
+	}
+	if models.TranslationPermissionLabel(t.Permission) == "" {
+		return nil, models.ErrBadPermission
+	}
+
+	if id := strings.TrimSpace(r.FormValue("original_book_id")); id != "" {
+		originalID, err := strconv.Atoi(id)
+		if err != nil || originalID <= 0 || originalID == bookID {
+			return nil, models.ErrBadOriginal
+		}
+		original, err := h.BookRepo.GetByID(originalID)
+		if err != nil {
+			return nil, err
+		}
+		if original == nil || !h.canViewBook(original, sessionUserID(r)) {
+			return nil, models.ErrBadOriginal
+		}
+		t.OriginalBookID = originalID
+	} else if strings.TrimSpace(t.OriginalTitle) == "" {
+		return nil, models.ErrBadOriginal
+	}
+
+	// Ссылка на оригинал показывается на странице работы, поэтому
+	// принимаем только http(s)
+	if t.OriginalURL != "" {
+		u, err := url.Parse(t.OriginalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, models.ErrBadOriginal
+		}
+	}
+	return t, nil
+}
+
+// saveTranslation сохраняет или снимает отметку перевода у работы bookID
+func (h *Handler) saveTranslation(bookID int, t *models.Translation) error {
+	if t == nil {
+		return h.BookRepo.DeleteTranslation(bookID)
+	}
+	t.BookID = bookID
+	return h.BookRepo.SaveTranslation(t)
+}
+
+// loadTranslations добавляет на страницу работы ее оригинал и переводы.
+// Оригинал, который зритель не может открыть, показывается как внешний.
+func (h *Handler) loadTranslations(data map[string]interface{}, bookID, viewerID int) {
+	translation, err := h.BookRepo.GetTranslation(bookID)
+	if err != nil {
+		h.Logger.Error("Get translation error:", err)
+	} else if translation != nil {
+		if translation.Original != nil && !h.canViewBook(translation.Original, viewerID) {
+			translation.Original = nil
+		}
+		data["Translation"] = translation
+	}
+
+	translations, err := h.BookRepo.GetTranslations(bookID, viewerID)
+	if err != nil {
+		h.Logger.Error("Get translations error:", err)
+		return
+	}
+	data["Translations"] = translations
+}
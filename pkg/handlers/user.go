@@ -8,16 +8,10 @@ import (
 	"sob/pkg/session"
 )
 
+// Index показывает гостям последние работы, а вошедшим пользователям -
+// персональную ленту; ?view=latest возвращает общую выдачу
 func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
-	books, err := h.BookRepo.GetLatest(12)
-	if err != nil {
-		h.Logger.Error("GetLatest books error:", err)
-		books = []*models.Book{}
-	}
-
-	data := map[string]interface{}{
-		"Books": books,
-	}
+	data := map[string]interface{}{}
 
 	// Получаем пользователя из сессии
 	sess, err := session.SessionFromContext(r.Context())
@@ -34,6 +28,32 @@ func (h *Handler) Index(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var page *models.BookPage
+	if user, ok := data["User"].(*models.User); ok && r.URL.Query().Get("view") != "latest" {
+		data["Feed"] = true
+		page, err = h.BookRepo.GetFeed(user.ID, pageRequest(r))
+		if err != nil {
+			h.Logger.Error("Get feed error:", err)
+		}
+		followedTags, err := h.FollowRepo.GetFollowedTags(user.ID)
+		if err != nil {
+			h.Logger.Error("Get followed tags error:", err)
+		}
+		data["FollowedTags"] = followedTags
+	} else {
+		page, err = h.BookRepo.GetLatest(sessionUserID(r), searchFilter(r), pageRequest(r))
+		if err != nil {
+			h.Logger.Error("GetLatest books error:", err)
+		}
+	}
+	if page == nil {
+		page = &models.BookPage{Books: []*models.Book{}}
+	}
+
+	data["Books"] = page.Books
+	addPagination(data, r, page)
+	addFilterNotice(data, r, page)
+
 	h.Tmpl.ExecuteTemplate(w, "index.html", data)
 }
 
@@ -117,18 +137,77 @@ func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	books, err := h.BookRepo.GetByUserID(int(sess.UserID))
+	page, err := h.BookRepo.GetByUserID(int(sess.UserID), int(sess.UserID), pageRequest(r))
 	if err != nil {
 		h.Logger.Error("Get user books error:", err)
-		books = []*models.Book{}
+		page = &models.BookPage{Books: []*models.Book{}}
+	}
+
+	bookCount, err := h.BookRepo.CountByUserID(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Count user books error:", err)
 	}
 
 	user, _ := h.UserRepo.GetByID(int(sess.UserID))
 
-	h.Tmpl.ExecuteTemplate(w, "profile.html", map[string]interface{}{
-		"Books": books,
-		"User":  user,
-	})
+	data := map[string]interface{}{
+		"Books":         page.Books,
+		"BookCount":     bookCount,
+		"User":          user,
+		"SavedSearches": h.savedSearchesWithCounts(int(sess.UserID)),
+		"Tab":           "works",
+	}
+
+	followerCount, err := h.FollowRepo.CountFollowers(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Count followers error:", err)
+	}
+	following, err := h.FollowRepo.GetFollowing(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Get following error:", err)
+	}
+	data["FollowerCount"] = followerCount
+	data["Following"] = following
+	addPagination(data, r, page)
+
+	// Вкладка полок
+	if r.URL.Query().Get("tab") == "shelves" {
+		data["Tab"] = "shelves"
+		data["Error"] = r.URL.Query().Get("error")
+
+		if err := h.ShelfRepo.EnsureDefaults(int(sess.UserID)); err != nil {
+			h.Logger.Error("Ensure default shelves error:", err)
+		}
+		shelves, err := h.ShelfRepo.GetByUserID(int(sess.UserID), true)
+		if err != nil {
+			h.Logger.Error("Get shelves error:", err)
+		}
+		data["Shelves"] = shelves
+	}
+
+	// Вкладка циклов
+	if r.URL.Query().Get("tab") == "series" {
+		data["Tab"] = "series"
+		data["Error"] = r.URL.Query().Get("error")
+		h.loadProfileSeries(data, int(sess.UserID))
+	}
+
+	// Приглашения в чужие работы
+	inviteCount, err := h.CoAuthorRepo.CountPendingInvites(int(sess.UserID))
+	if err != nil {
+		h.Logger.Error("Count author invites error:", err)
+	}
+	data["InviteCount"] = inviteCount
+	if r.URL.Query().Get("tab") == "invitations" {
+		data["Tab"] = "invitations"
+		invites, err := h.CoAuthorRepo.PendingInvites(int(sess.UserID))
+		if err != nil {
+			h.Logger.Error("Get author invites error:", err)
+		}
+		data["Invites"] = invites
+	}
+
+	h.Tmpl.ExecuteTemplate(w, "profile.html", data)
 }
 
 func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
@@ -141,20 +220,20 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 		tags = strings.Split(tagsParam, ",")
 	}
 
-	var books []*models.Book
+	var page *models.BookPage
 	var err error
 
 	if query != "" || len(tags) > 0 {
-		books, err = h.BookRepo.Search(query, tags, sortBy)
+		page, err = h.BookRepo.Search(sessionUserID(r), query, tags, searchFilter(r), sortBy, pageRequest(r))
 		if err != nil {
 			h.Logger.Error("Search books error:", err)
-			books = []*models.Book{}
+			page = &models.BookPage{Books: []*models.Book{}}
 		}
 	} else {
-		books, err = h.BookRepo.GetLatest(12)
+		page, err = h.BookRepo.GetLatest(sessionUserID(r), searchFilter(r), pageRequest(r))
 		if err != nil {
 			h.Logger.Error("GetLatest books error:", err)
-			books = []*models.Book{}
+			page = &models.BookPage{Books: []*models.Book{}}
 		}
 	}
 
@@ -162,12 +241,14 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 	popularTags, _ := h.BookRepo.GetPopularTags(20)
 
 	data := map[string]interface{}{
-		"Books":       books,
+		"Books":       page.Books,
 		"Query":       query,
 		"Tags":        tags,
 		"SortBy":      sortBy,
 		"PopularTags": popularTags,
 	}
+	addPagination(data, r, page)
+	addFilterNotice(data, r, page)
 
 	// Получаем пользователя из сессии
 	if sess, err := session.SessionFromContext(r.Context()); err == nil && sess != nil {
@@ -176,6 +257,8 @@ func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
 			h.Logger.Error("Get user by ID error:", err)
 		} else {
 			data["User"] = user
+			data["TagFollows"] = h.tagFollowStates(user.ID, tags)
+			data["CurrentURL"] = r.URL.RequestURI()
 		}
 	}
 
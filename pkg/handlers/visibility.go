@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sob/pkg/models"
+)
+
+// visibilityForm читает уровень видимости работы; по умолчанию - публичная
+func visibilityForm(r *http.Request) (string, error) {
+	visibility := r.FormValue("visibility")
+	if visibility == "" {
+		return models.VisibilityPublic, nil
+	}
+	if models.VisibilityLabel(visibility) == "" {
+		return "", models.ErrBadVisibility
+	}
+	return visibility, nil
+}
+
+// uploadFilename возвращает имя файла для новой загрузки. Случайная часть
+// делает путь уникальным: повторная загрузка файла с тем же именем не
+// перезаписывает чужую работу, и по пути всегда находится одна работа.
+func uploadFilename(userID int, name string) string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return fmt.Sprintf("%d_%x_%s", userID, buf, filepath.Base(name))
+}
+
+// ServeUpload отдает файлы работ из UploadDir с той же проверкой видимости,
+// что и страница работы. Файлы, не принадлежащие ни одной работе, не
+// отдаются; если старый файл делят несколько работ, читатель должен видеть
+// каждую из них.
+func (h *Handler) ServeUpload(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/uploads/")
+	if name == "" || strings.ContainsAny(name, `/\`) || name != path.Base(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	filePath := filepath.Join(h.UploadDir, name)
+	books, err := h.BookRepo.GetByFilePath(filepath.ToSlash(filePath))
+	if err != nil {
+		h.Logger.Error("Get book by file path error:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if len(books) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	for _, book := range books {
+		if !h.canViewBook(book, sessionUserID(r)) {
+			http.NotFound(w, r)
+			return
+		}
+		// Закрытые работы не должны оседать в общих кэшах
+		if !book.IsListed() || book.IsDraft() {
+			w.Header().Set("Cache-Control", "private, no-store")
+		}
+	}
+	http.ServeFile(w, r, filePath)
+}
@@ -0,0 +1,152 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Message - письмо с текстовой и HTML-версией. Headers добавляются как есть,
+// например List-Unsubscribe.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Mailer отправляет письма. Реализация выбирается при запуске, остальной
+// код о способе доставки не знает.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// LogMailer только пишет письма в лог; используется, когда SMTP не настроен
+type LogMailer struct {
+	Logger *zap.SugaredLogger
+}
+
+func (m *LogMailer) Send(msg *Message) error {
+	m.Logger.Infow("Email (not sent, SMTP is not configured)",
+		"to", msg.To,
+		"subject", msg.Subject,
+		"text", msg.Text,
+	)
+	return nil
+}
+
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	Host     string
+}
+
+func (m *SMTPMailer) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	body, err := m.build(msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, body)
+}
+
+// build собирает multipart/alternative письмо
+func (m *SMTPMailer) build(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := map[string]string{
+		"From":         m.From,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   messageID(m.Host),
+		"MIME-Version": "1.0",
+		"Content-Type": "multipart/alternative; boundary=" + writer.Boundary(),
+	}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	var head strings.Builder
+	for key, value := range headers {
+		fmt.Fprintf(&head, "%s: %s\r\n", key, value)
+	}
+	head.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	}
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return append([]byte(head.String()), buf.Bytes()...), nil
+}
+
+func messageID(host string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return fmt.Sprintf("<%x@%s>", buf, host)
+}
+
+// FromEnv создает SMTP-отправителя по переменным SMTP_HOST, SMTP_PORT,
+// SMTP_USER, SMTP_PASSWORD и MAIL_FROM; без SMTP_HOST письма только логируются
+func FromEnv(logger *zap.SugaredLogger) Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &LogMailer{Logger: logger}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@" + host
+	}
+
+	return &SMTPMailer{
+		Addr:     host + ":" + port,
+		From:     from,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		Host:     host,
+	}
+}
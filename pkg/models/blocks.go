@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrSelfBlock = errors.New("you can't block yourself")
+
+// BlockRepo хранит блокировки и заглушенных авторов
+type BlockRepo struct {
+	DB *sql.DB
+}
+
+func NewBlockRepo(db *sql.DB) *BlockRepo {
+	return &BlockRepo{DB: db}
+}
+
+func (r *BlockRepo) Block(blockerID, blockedID int) error {
+	if blockerID == blockedID {
+		return ErrSelfBlock
+	}
+	_, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO blocks (blocker_id, blocked_id) VALUES (?, ?)
+	`, blockerID, blockedID)
+	return err
+}
+
+func (r *BlockRepo) Unblock(blockerID, blockedID int) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID,
+	)
+	return err
+}
+
+func (r *BlockRepo) HasBlocked(blockerID, blockedID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM blocks WHERE blocker_id = ? AND blocked_id = ?)",
+		blockerID, blockedID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// GetBlocked возвращает пользователей, заблокированных userID
+func (r *BlockRepo) GetBlocked(userID int) ([]*User, error) {
+	return r.listUsers(`
+		SELECT u.id, u.username, u.avatar
+		FROM blocks b
+		JOIN users u ON b.blocked_id = u.id
+		WHERE b.blocker_id = ?
+		ORDER BY u.username
+	`, userID)
+}
+
+func (r *BlockRepo) listUsers(query string, args ...interface{}) ([]*User, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u := &User{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Avatar); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// mutedAuthorsCondition исключает из выборки книг работы заглушенных авторов;
+// параметр - ID смотрящего пользователя
+const mutedAuthorsCondition = "b.user_id NOT IN (SELECT m.muted_id FROM mutes m WHERE m.muter_id = ?)"
+
+// Mute скрывает работы автора из выдачи пользователя, не мешая общению
+func (r *BlockRepo) Mute(muterID, mutedID int) error {
+	if muterID == mutedID {
+		return ErrSelfBlock
+	}
+	_, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO mutes (muter_id, muted_id) VALUES (?, ?)
+	`, muterID, mutedID)
+	return err
+}
+
+func (r *BlockRepo) Unmute(muterID, mutedID int) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM mutes WHERE muter_id = ? AND muted_id = ?", muterID, mutedID,
+	)
+	return err
+}
+
+func (r *BlockRepo) HasMuted(muterID, mutedID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM mutes WHERE muter_id = ? AND muted_id = ?)",
+		muterID, mutedID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// GetMuted возвращает авторов, заглушенных userID
+func (r *BlockRepo) GetMuted(userID int) ([]*User, error) {
+	return r.listUsers(`
+		SELECT u.id, u.username, u.avatar
+		FROM mutes m
+		JOIN users u ON m.muted_id = u.id
+		WHERE m.muter_id = ?
+		ORDER BY u.username
+	`, userID)
+}
+
+// blockedBetween сообщает, заблокировал ли кто-то из пары другого
+func blockedBetween(db *sql.DB, a, b int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM blocks
+			WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)
+		)
+	`, a, b, b, a).Scan(&exists)
+	return exists, err
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"strings"
+	"time"
 )
 
 type Book struct {
@@ -17,10 +18,58 @@ type Book struct {
 	Tags        string  `json:"tags"`
 	Rating      float64 `json:"rating"`
 	RatingCount int     `json:"rating_count"`
+	KudosCount  int     `json:"kudos_count"`
 	UserID      int     `json:"user_id"`
 	Username    string  `json:"username"`
 	UserRating  int     `json:"user_rating"`
 	CreatedAt   string  `json:"created_at"`
+	// ContentRating - один из Rating*, Warnings - значения Warning* через запятую
+	ContentRating string `json:"content_rating"`
+	Warnings      string `json:"warnings"`
+	// Status - один из Status*; остальные поля считаются по тексту файла
+	Status            string  `json:"status"`
+	WordCount         int     `json:"word_count"`
+	ChapterCount      int     `json:"chapter_count"`
+	CharCount         int     `json:"char_count"`
+	DialogueRatio     float64 `json:"dialogue_ratio"`
+	AvgSentenceLength float64 `json:"avg_sentence_length"`
+	// Language - код языка из Languages
+	Language string `json:"language"`
+	// Visibility - один из Visibility*; Draft - работа еще не опубликована.
+	// PublishedAt - время публикации, у черновика - запланированное время
+	// или пустая строка
+	Visibility  string `json:"visibility"`
+	Draft       bool   `json:"draft"`
+	PublishedAt string `json:"published_at"`
+	// FeedReason и FeedAt заполняются только в персональной ленте
+	FeedReason string `json:"feed_reason,omitempty"`
+	FeedAt     string `json:"feed_at,omitempty"`
+}
+
+// bookColumns - общий список колонок для выборок книг, порядок совпадает
+// со scanBook
+const bookColumns = `b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size,
+		       b.cover_image, b.tags, b.content_rating, b.warnings, b.status, b.word_count, b.chapter_count,
+		       b.char_count, b.dialogue_ratio, b.avg_sentence_length, b.language,
+		       b.visibility, b.draft, COALESCE(b.published_at, ''), b.rating, b.rating_count, b.kudos_count, b.user_id, u.username, b.created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBook читает строку, выбранную через bookColumns; extra - дополнительные
+// колонки, идущие после основных
+func scanBook(row rowScanner, extra ...interface{}) (*Book, error) {
+	book := &Book{}
+	dest := []interface{}{&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename,
+		&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.ContentRating, &book.Warnings,
+		&book.Status, &book.WordCount, &book.ChapterCount, &book.CharCount, &book.DialogueRatio, &book.AvgSentenceLength, &book.Language,
+		&book.Visibility, &book.Draft, &book.PublishedAt, &book.Rating,
+		&book.RatingCount, &book.KudosCount, &book.UserID, &book.Username, &book.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return book, nil
 }
 
 type BookRepo struct {
@@ -32,9 +81,17 @@ func NewBookRepo(db *sql.DB) *BookRepo {
 }
 
 func (r *BookRepo) Create(book *Book) (int64, error) {
+	if book.Visibility == "" {
+		book.Visibility = VisibilityPublic
+	}
+	if !book.Draft && book.PublishedAt == "" {
+		book.PublishedAt = time.Now().UTC().Format(TimeLayout)
+	}
 	result, err := r.DB.Exec(
-		"INSERT INTO books (title, author, description, filename, file_path, file_size, cover_image, tags, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		book.Title, book.Author, book.Description, book.Filename, book.FilePath, book.FileSize, book.CoverImage, book.Tags, book.UserID,
+		"INSERT INTO books (title, author, description, filename, file_path, file_size, cover_image, tags, content_rating, warnings, status, word_count, chapter_count, char_count, dialogue_ratio, avg_sentence_length, language, visibility, draft, published_at, user_id, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		book.Title, book.Author, book.Description, book.Filename, book.FilePath, book.FileSize, book.CoverImage, book.Tags, book.ContentRating, book.Warnings,
+		book.Status, book.WordCount, book.ChapterCount, book.CharCount, book.DialogueRatio, book.AvgSentenceLength, book.Language,
+		book.Visibility, book.Draft, nullableString(book.PublishedAt), book.UserID,
 	)
 	if err != nil {
 		return 0, err
@@ -42,45 +99,34 @@ func (r *BookRepo) Create(book *Book) (int64, error) {
 	return result.LastInsertId()
 }
 
-func (r *BookRepo) GetLatest(limit int) ([]*Book, error) {
-	rows, err := r.DB.Query(`
-		SELECT b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size, 
-		       b.cover_image, b.tags, b.rating, b.rating_count, b.user_id, u.username, b.created_at
-		FROM books b
-		JOIN users u ON b.user_id = u.id
-		ORDER BY b.created_at DESC
-		LIMIT ?
-	`, limit)
+// GetLatest возвращает последние книги; работы авторов, которых viewerID
+// заглушил, не показываются (0 - гость). Личный фильтр читателя применяется,
+// если filter.ShowFiltered не выставлен.
+func (r *BookRepo) GetLatest(viewerID int, filter SearchFilter, page PageRequest) (*BookPage, error) {
+	whereClause, args, err := r.searchWhere(viewerID, "", nil, filter)
+	if err != nil {
+		return nil, err
+	}
+	whereClause, args, hidden, err := r.applyContentFilter(viewerID, filter.ShowFiltered, whereClause, args)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var books []*Book
-	for rows.Next() {
-		book := &Book{}
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename, 
-			&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.Rating, 
-			&book.RatingCount, &book.UserID, &book.Username, &book.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		books = append(books, book)
+	result, err := r.listBooks(whereClause, args, "newest", page)
+	if err != nil {
+		return nil, err
 	}
-	return books, nil
+	result.Hidden = hidden
+	return result, nil
 }
 
 func (r *BookRepo) GetByID(id int) (*Book, error) {
-	book := &Book{}
-	err := r.DB.QueryRow(`
-		SELECT b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size, 
-		       b.cover_image, b.tags, b.rating, b.rating_count, b.user_id, u.username, b.created_at
+	book, err := scanBook(r.DB.QueryRow(`
+		SELECT `+bookColumns+`
 		FROM books b
 		JOIN users u ON b.user_id = u.id
 		WHERE b.id = ?
-	`, id).Scan(&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename, 
-		&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.Rating, 
-		&book.RatingCount, &book.UserID, &book.Username, &book.CreatedAt)
+	`, id))
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -89,96 +135,326 @@ func (r *BookRepo) GetByID(id int) (*Book, error) {
 }
 
 func (r *BookRepo) GetByIDWithUserRating(id, userID int) (*Book, error) {
-	book := &Book{}
-	err := r.DB.QueryRow(`
-		SELECT b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size, 
-		       b.cover_image, b.tags, b.rating, b.rating_count, b.user_id, u.username, b.created_at,
+	var userRating int
+	book, err := scanBook(r.DB.QueryRow(`
+		SELECT `+bookColumns+`,
 		       COALESCE(rt.rating, 0) as user_rating
 		FROM books b
 		JOIN users u ON b.user_id = u.id
 		LEFT JOIN ratings rt ON b.id = rt.book_id AND rt.user_id = ?
 		WHERE b.id = ?
-	`, userID, id).Scan(&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename, 
-		&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.Rating, 
-		&book.RatingCount, &book.UserID, &book.Username, &book.CreatedAt, &book.UserRating)
+	`, userID, id), &userRating)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if book != nil {
+		book.UserRating = userRating
+	}
 	return book, err
 }
 
-func (r *BookRepo) GetByUserID(userID int) ([]*Book, error) {
-	rows, err := r.DB.Query(`
-		SELECT b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size, 
-		       b.cover_image, b.tags, b.rating, b.rating_count, b.created_at
+// GetByUserID возвращает работы пользователя - и свои, и те, где он
+// принял приглашение соавтора или другого участника. Черновики и работы
+// вне списков видны, только если viewerID сам участник работы.
+func (r *BookRepo) GetByUserID(viewerID, userID int, page PageRequest) (*BookPage, error) {
+	visible, args := visibleToCondition(viewerID)
+	return r.listBooks("WHERE "+participantCondition+" AND "+visible,
+		append([]interface{}{userID, userID}, args...), "newest", page)
+}
+
+// CountByUserID возвращает число книг пользователя, включая совместные
+func (r *BookRepo) CountByUserID(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow(
+		"SELECT COUNT(*) FROM books b WHERE "+participantCondition, userID, userID,
+	).Scan(&count)
+	return count, err
+}
+
+// AuthorStats - сводка по работам автора для публичного профиля
+type AuthorStats struct {
+	Works     int
+	Kudos     int
+	Ratings   int
+	AvgRating float64
+}
+
+// GetAuthorStats считает число работ автора (включая совместные), которые
+// viewerID видит в его профиле, их кудосы и среднюю оценку; средняя
+// взвешена по числу оценок каждой работы
+func (r *BookRepo) GetAuthorStats(viewerID, userID int) (*AuthorStats, error) {
+	stats := &AuthorStats{}
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(kudos_count), 0), COALESCE(SUM(rating_count), 0),
+		       COALESCE(SUM(rating * rating_count) / NULLIF(SUM(rating_count), 0), 0)
 		FROM books b
-		WHERE b.user_id = ?
-		ORDER BY b.created_at DESC
-	`, userID)
+		WHERE `+participantCondition+` AND `+listedCondition(viewerID)+`
+	`, userID, userID).Scan(&stats.Works, &stats.Kudos, &stats.Ratings, &stats.AvgRating)
+	return stats, err
+}
+
+// SearchFilter - структурные фильтры поиска поверх текста и тегов
+type SearchFilter struct {
+	// Ratings оставляет только работы с этими рейтингами
+	Ratings []string
+	// ExcludeWarnings убирает работы с любым из этих предупреждений
+	ExcludeWarnings []string
+	// Statuses и Lengths оставляют работы с этими статусами и диапазонами длины
+	Statuses []string
+	Lengths  []string
+	// Languages оставляет работы на этих языках
+	Languages []string
+	// WithTags сужает выдачу: работа должна иметь каждый из этих тегов.
+	// Сюда попадают теги, выбранные в фасетах, а не в строке поиска.
+	WithTags []string
+	// ShowFiltered временно отключает личный фильтр читателя
+	ShowFiltered bool
+}
+
+func (r *BookRepo) Search(viewerID int, query string, tags []string, filter SearchFilter, sortBy string, page PageRequest) (*BookPage, error) {
+	whereClause, args, err := r.searchWhere(viewerID, query, tags, filter)
+	if err != nil {
+		return nil, err
+	}
+	whereClause, args, hidden, err := r.applyContentFilter(viewerID, filter.ShowFiltered, whereClause, args)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var books []*Book
-	for rows.Next() {
-		book := &Book{}
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename, 
-			&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.Rating, 
-			&book.RatingCount, &book.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		books = append(books, book)
+	result, err := r.listBooks(whereClause, args, sortBy, page)
+	if err != nil {
+		return nil, err
+	}
+	result.Hidden = hidden
+
+	// Фасеты считаются по всей выдаче, а не только по текущей странице
+	result.Facets, err = r.searchFacets(whereClause, args)
+	if err != nil {
+		return nil, err
 	}
-	return books, nil
+	return result, nil
 }
 
-func (r *BookRepo) Search(query string, tags []string, sortBy string) ([]*Book, error) {
-	var whereClause string
+// CountNewMatches считает работы, подходящие под поиск с теми же фильтрами,
+// что и выдача, и опубликованные позже after
+func (r *BookRepo) CountNewMatches(viewerID int, query string, tags []string, filter SearchFilter, after string) (int, error) {
+	whereClause, args, err := r.searchWhere(viewerID, query, tags, filter)
+	if err != nil {
+		return 0, err
+	}
+	whereClause, args, _, err = r.applyContentFilter(viewerID, filter.ShowFiltered, whereClause, args)
+	if err != nil {
+		return 0, err
+	}
+	if whereClause != "" {
+		whereClause += " AND " + publishedAtColumn + " > ?"
+	} else {
+		whereClause = "WHERE " + publishedAtColumn + " > ?"
+	}
+	args = append(args, after)
+
+	var count int
+	err = r.DB.QueryRow(`
+		SELECT COUNT(*) FROM books b JOIN users u ON b.user_id = u.id
+		`+whereClause, args...).Scan(&count)
+	return count, err
+}
+
+// LatestPublishedAt возвращает время публикации последней опубликованной работы
+func (r *BookRepo) LatestPublishedAt() (string, error) {
+	var at string
+	err := r.DB.QueryRow("SELECT " + latestPublishedQuery).Scan(&at)
+	return at, err
+}
+
+// searchWhere собирает условие поиска. Подходит работа с любым из тегов
+// tags и со всеми тегами filter.WithTags; каждый тег раскрывается во все
+// свои синонимы и дочерние теги. В выдачу попадают
+// только работы, которые viewerID может встретить в списках; работы
+// авторов, которых он заглушил, исключаются.
+func (r *BookRepo) searchWhere(viewerID int, query string, tags []string, filter SearchFilter) (string, []interface{}, error) {
+	conditions := []string{listedCondition(viewerID)}
 	var args []interface{}
-	
+
+	if viewerID > 0 {
+		conditions = append(conditions, mutedAuthorsCondition)
+		args = append(args, viewerID)
+	}
+
 	if query != "" {
-		whereClause = "WHERE (b.title LIKE ? OR b.author LIKE ? OR b.description LIKE ? OR b.tags LIKE ?)"
+		conditions = append(conditions, "(b.title LIKE ? OR b.author LIKE ? OR b.description LIKE ? OR b.tags LIKE ?)")
 		searchTerm := "%" + query + "%"
 		args = append(args, searchTerm, searchTerm, searchTerm, searchTerm)
 	}
-	
-	if len(tags) > 0 {
+
+	if len(filter.Ratings) > 0 {
+		var condition string
+		condition, args = inCondition("b.content_rating", filter.Ratings, args)
+		conditions = append(conditions, condition)
+	}
+
+	if len(filter.Statuses) > 0 {
+		var condition string
+		condition, args = inCondition("b.status", filter.Statuses, args)
+		conditions = append(conditions, condition)
+	}
+
+	if len(filter.Lengths) > 0 {
+		var condition string
+		condition, args = inCondition("("+lengthBucketExpr+")", filter.Lengths, args)
+		conditions = append(conditions, condition)
+	}
+
+	if len(filter.Languages) > 0 {
+		var condition string
+		condition, args = inCondition("b.language", filter.Languages, args)
+		conditions = append(conditions, condition)
+	}
+
+	// Предупреждения хранятся списком через запятую, поэтому сравниваем
+	// с обрамляющими запятыми, чтобы одно значение не совпало с частью другого
+	for _, warning := range filter.ExcludeWarnings {
+		conditions = append(conditions, "(',' || b.warnings || ',') NOT LIKE ?")
+		args = append(args, "%,"+warning+",%")
+	}
+
+	var tagNames []string
+	for _, tag := range tags {
+		if NormalizeTag(tag) != "" {
+			tagNames = append(tagNames, tag)
+		}
+	}
+	if len(tagNames) > 0 {
+		// Канонический тег раскрывается во все синонимы и дочерние теги
+		tagIDs, err := expandTagNames(r.DB, tagNames)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions, args = appendTagCondition(conditions, args, tagIDs)
+	}
+
+	for _, tag := range filter.WithTags {
+		tagIDs, err := expandTagNames(r.DB, []string{tag})
+		if err != nil {
+			return "", nil, err
+		}
+		conditions, args = appendTagCondition(conditions, args, tagIDs)
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// appendTagCondition добавляет условие "у работы есть один из тегов tagIDs";
+// пустой список тегов не пропускает ни одной работы
+func appendTagCondition(conditions []string, args []interface{}, tagIDs []int) ([]string, []interface{}) {
+	if len(tagIDs) == 0 {
+		return append(conditions, "0"), args
+	}
+	placeholders := make([]string, len(tagIDs))
+	for i, id := range tagIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	return append(conditions, "b.id IN (SELECT bt.book_id FROM book_tags bt WHERE bt.tag_id IN ("+
+		strings.Join(placeholders, ",")+"))"), args
+}
+
+// applyContentFilter дополняет условие личным фильтром viewerID и считает,
+// сколько подходящих работ фильтр скрывает. При showFiltered фильтр не
+// применяется, но счетчик заполняется, чтобы страница могла предложить
+// включить его обратно.
+func (r *BookRepo) applyContentFilter(viewerID int, showFiltered bool, whereClause string, args []interface{}) (string, []interface{}, int, error) {
+	if viewerID <= 0 {
+		return whereClause, args, 0, nil
+	}
+
+	filter, err := loadContentFilter(r.DB, viewerID)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	condition, conditionArgs, err := contentFilterCondition(r.DB, filter)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if condition == "" {
+		return whereClause, args, 0, nil
+	}
+
+	hiddenWhere := "WHERE NOT " + condition
+	if whereClause != "" {
+		hiddenWhere = whereClause + " AND NOT " + condition
+	}
+	var hidden int
+	err = r.DB.QueryRow(`
+		SELECT COUNT(*) FROM books b JOIN users u ON b.user_id = u.id
+		`+hiddenWhere, append(append([]interface{}{}, args...), conditionArgs...)...).Scan(&hidden)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	if showFiltered {
+		return whereClause, args, hidden, nil
+	}
+	if whereClause != "" {
+		whereClause += " AND " + condition
+	} else {
+		whereClause = "WHERE " + condition
+	}
+	return whereClause, append(args, conditionArgs...), hidden, nil
+}
+
+// listBooks выбирает страницу книг с курсорной пагинацией. Порядок всегда
+// заканчивается на b.id, поэтому при равных значениях сортировки страницы
+// не пересекаются и не теряют книги.
+func (r *BookRepo) listBooks(whereClause string, args []interface{}, sortBy string, page PageRequest) (*BookPage, error) {
+	columns, ok := bookSortColumns[sortBy]
+	if !ok {
+		columns = bookSortColumns["newest"]
+	}
+
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	backward, anchorID := page.anchor()
+	if anchorID != 0 {
+		// Сравнение кортежей с ключами сортировки книги-якоря
+		op := "<"
+		if backward {
+			op = ">"
+		}
+		keyset := "(" + strings.Join(columns, ", ") + ") " + op +
+			" (SELECT " + strings.Join(columns, ", ") + " FROM books b WHERE b.id = ?)"
 		if whereClause != "" {
-			whereClause += " AND "
+			whereClause += " AND " + keyset
 		} else {
-			whereClause = "WHERE "
-		}
-		
-		tagConditions := []string{}
-		for _, tag := range tags {
-			tagConditions = append(tagConditions, "b.tags LIKE ?")
-			args = append(args, "%"+tag+"%")
+			whereClause = "WHERE " + keyset
 		}
-		whereClause += "(" + strings.Join(tagConditions, " OR ") + ")"
+		args = append(args, anchorID)
 	}
-	
-	var orderBy string
-	switch sortBy {
-	case "rating":
-		orderBy = "b.rating DESC, b.created_at DESC"
-	case "newest":
-		orderBy = "b.created_at DESC"
-	case "popular":
-		orderBy = "b.rating_count DESC, b.rating DESC"
-	default:
-		orderBy = "b.created_at DESC"
+
+	direction := " DESC"
+	if backward {
+		direction = " ASC"
+	}
+	orderBy := make([]string, len(columns))
+	for i, column := range columns {
+		orderBy[i] = column + direction
 	}
 
 	sqlQuery := `
-		SELECT b.id, b.title, b.author, b.description, b.filename, b.file_path, b.file_size, 
-		       b.cover_image, b.tags, b.rating, b.rating_count, b.user_id, u.username, b.created_at
+		SELECT ` + bookColumns + `
 		FROM books b
 		JOIN users u ON b.user_id = u.id
 		` + whereClause + `
-		ORDER BY ` + orderBy
+		ORDER BY ` + strings.Join(orderBy, ", ") + `
+		LIMIT ?`
+	args = append(args, limit+1)
 
 	rows, err := r.DB.Query(sqlQuery, args...)
 	if err != nil {
@@ -186,18 +462,19 @@ func (r *BookRepo) Search(query string, tags []string, sortBy string) ([]*Book,
 	}
 	defer rows.Close()
 
-	var books []*Book
+	books := []*Book{}
 	for rows.Next() {
-		book := &Book{}
-		err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Description, &book.Filename, 
-			&book.FilePath, &book.FileSize, &book.CoverImage, &book.Tags, &book.Rating, 
-			&book.RatingCount, &book.UserID, &book.Username, &book.CreatedAt)
+		book, err := scanBook(rows)
 		if err != nil {
 			return nil, err
 		}
 		books = append(books, book)
 	}
-	return books, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newBookPage(books, limit, backward, anchorID != 0), nil
 }
 
 func (r *BookRepo) Delete(bookID, userID int) error {
@@ -258,8 +535,8 @@ func (r *BookRepo) GetPopularTags(limit int) ([]string, error) {
 	rows, err := r.DB.Query(`
 		WITH split_tags AS (
 			SELECT DISTINCT trim(value) as tag
-			FROM books, json_each('["' || replace(tags, ',', '","') || '"]')
-			WHERE tags != ''
+			FROM books b, json_each('["' || replace(b.tags, ',', '","') || '"]')
+			WHERE b.tags != '' AND `+listedCondition(0)+`
 		)
 		SELECT tag
 		FROM split_tags
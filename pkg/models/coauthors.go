@@ -0,0 +1,233 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Роли участников работы
+const (
+	RoleAuthor      = "author"
+	RoleCoAuthor    = "co_author"
+	RoleTranslator  = "translator"
+	RoleBeta        = "beta"
+	RoleIllustrator = "illustrator"
+)
+
+var AuthorRoles = []Option{
+	{RoleAuthor, "Author"},
+	{RoleCoAuthor, "Co-Author"},
+	{RoleTranslator, "Translator"},
+	{RoleBeta, "Beta Reader"},
+	{RoleIllustrator, "Illustrator"},
+}
+
+// AuthorRoleLabel возвращает подпись роли или пустую строку
+func AuthorRoleLabel(role string) string {
+	return optionLabel(AuthorRoles, role)
+}
+
+// Состояние приглашения: пока участник его не принял, он не указан
+// в работе и не может ее редактировать
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+)
+
+var (
+	ErrBadRole        = errors.New("unknown author role")
+	ErrSelfInvite     = errors.New("you are already the owner of this work")
+	ErrAlreadyInvited = errors.New("this user is already invited")
+	ErrNoInvite       = errors.New("invitation not found")
+	ErrInviteBlocked  = errors.New("this user can't be invited")
+	ErrNotBookOwner   = errors.New("only the owner of the work can manage its authors")
+)
+
+// BookAuthor - участник работы помимо владельца (books.user_id)
+type BookAuthor struct {
+	BookID    int    `json:"book_id"`
+	BookTitle string `json:"book_title"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Status    string `json:"status"`
+	InvitedBy string `json:"invited_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (a *BookAuthor) RoleLabel() string {
+	return AuthorRoleLabel(a.Role)
+}
+
+func (a *BookAuthor) IsPending() bool {
+	return a.Status == InvitePending
+}
+
+// CanEdit - авторы и соавторы правят работу наравне с владельцем;
+// переводчики, беты и иллюстраторы только указываются в ней
+func (a *BookAuthor) CanEdit() bool {
+	return a.Status == InviteAccepted && (a.Role == RoleAuthor || a.Role == RoleCoAuthor)
+}
+
+// coAuthoredCondition отбирает работы, где пользователь - принявший
+// приглашение участник; параметр - id пользователя
+const coAuthoredCondition = `b.id IN (
+		SELECT book_id FROM book_authors WHERE user_id = ? AND status = '` + InviteAccepted + `')`
+
+type CoAuthorRepo struct {
+	DB *sql.DB
+}
+
+func NewCoAuthorRepo(db *sql.DB) *CoAuthorRepo {
+	return &CoAuthorRepo{DB: db}
+}
+
+const bookAuthorColumns = `ba.book_id, b.title, ba.user_id, u.username, ba.role, ba.status,
+		       COALESCE(inv.username, ''), ba.created_at`
+
+func (r *CoAuthorRepo) list(where string, args ...interface{}) ([]*BookAuthor, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+bookAuthorColumns+`
+		FROM book_authors ba
+		JOIN books b ON ba.book_id = b.id
+		JOIN users u ON ba.user_id = u.id
+		LEFT JOIN users inv ON ba.invited_by = inv.id
+		`+where+`
+		ORDER BY ba.created_at, ba.user_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var authors []*BookAuthor
+	for rows.Next() {
+		a := &BookAuthor{}
+		err := rows.Scan(&a.BookID, &a.BookTitle, &a.UserID, &a.Username, &a.Role, &a.Status,
+			&a.InvitedBy, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+	return authors, rows.Err()
+}
+
+// GetByBook возвращает участников работы, включая еще не принятые приглашения
+func (r *CoAuthorRepo) GetByBook(bookID int) ([]*BookAuthor, error) {
+	return r.list("WHERE ba.book_id = ?", bookID)
+}
+
+// GetCredits возвращает участников, которые указываются в работе
+func (r *CoAuthorRepo) GetCredits(bookID int) ([]*BookAuthor, error) {
+	return r.list("WHERE ba.book_id = ? AND ba.status = ?", bookID, InviteAccepted)
+}
+
+// PendingInvites возвращает приглашения, ожидающие ответа пользователя
+func (r *CoAuthorRepo) PendingInvites(userID int) ([]*BookAuthor, error) {
+	return r.list("WHERE ba.user_id = ? AND ba.status = ?", userID, InvitePending)
+}
+
+// CountPendingInvites - число приглашений, ожидающих ответа пользователя
+func (r *CoAuthorRepo) CountPendingInvites(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow(
+		"SELECT COUNT(*) FROM book_authors WHERE user_id = ? AND status = ?", userID, InvitePending,
+	).Scan(&count)
+	return count, err
+}
+
+// CanEdit сообщает, может ли пользователь редактировать работу: владелец
+// может всегда, участники - если их роль это позволяет
+func (r *CoAuthorRepo) CanEdit(book *Book, userID int) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	if book.UserID == userID {
+		return true, nil
+	}
+
+	a := &BookAuthor{}
+	err := r.DB.QueryRow(
+		"SELECT role, status FROM book_authors WHERE book_id = ? AND user_id = ?", book.ID, userID,
+	).Scan(&a.Role, &a.Status)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return a.CanEdit(), nil
+}
+
+// Invite приглашает пользователя в работу с указанной ролью. Приглашать
+// может только владелец работы.
+func (r *CoAuthorRepo) Invite(book *Book, ownerID, userID int, role string) error {
+	if AuthorRoleLabel(role) == "" {
+		return ErrBadRole
+	}
+	if book.UserID != ownerID {
+		return ErrNotBookOwner
+	}
+	if userID == ownerID {
+		return ErrSelfInvite
+	}
+
+	blocked, err := blockedBetween(r.DB, ownerID, userID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrInviteBlocked
+	}
+
+	result, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO book_authors (book_id, user_id, role, status, invited_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, book.ID, userID, role, InvitePending, ownerID)
+	if err != nil {
+		return err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrAlreadyInvited
+	}
+	return nil
+}
+
+// Accept принимает приглашение пользователя в работу
+func (r *CoAuthorRepo) Accept(bookID, userID int) error {
+	result, err := r.DB.Exec(`
+		UPDATE book_authors SET status = ?
+		WHERE book_id = ? AND user_id = ? AND status = ?
+	`, InviteAccepted, bookID, userID, InvitePending)
+	if err != nil {
+		return err
+	}
+	accepted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if accepted == 0 {
+		return ErrNoInvite
+	}
+	return nil
+}
+
+// Leave убирает пользователя из работы: так отклоняют приглашение или
+// перестают быть участником
+func (r *CoAuthorRepo) Leave(bookID, userID int) error {
+	_, err := r.DB.Exec("DELETE FROM book_authors WHERE book_id = ? AND user_id = ?", bookID, userID)
+	return err
+}
+
+// Remove убирает участника или отзывает приглашение; доступно владельцу работы
+func (r *CoAuthorRepo) Remove(book *Book, ownerID, userID int) error {
+	if book.UserID != ownerID {
+		return ErrNotBookOwner
+	}
+	return r.Leave(book.ID, userID)
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrNoComment = errors.New("comment not found")
+
+const MaxCommentLength = 10000
+
+type Comment struct {
+	ID        int    `json:"id"`
+	BookID    int    `json:"book_id"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	ParentID  int    `json:"parent_id"`
+	Chapter   int    `json:"chapter"`
+	Body      string `json:"body"`
+	Hidden    bool   `json:"hidden"`
+	Deleted   bool   `json:"deleted"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+
+	Replies []*Comment `json:"replies"`
+	Depth   int        `json:"depth"`
+
+	// Права текущего пользователя, заполняются обработчиком
+	CanEdit     bool `json:"-"`
+	CanModerate bool `json:"-"`
+	CanReply    bool `json:"-"`
+}
+
+// Edited сообщает, редактировался ли комментарий после публикации
+func (c *Comment) Edited() bool {
+	return c.UpdatedAt != "" && c.UpdatedAt != c.CreatedAt
+}
+
+type CommentRepo struct {
+	DB *sql.DB
+}
+
+func NewCommentRepo(db *sql.DB) *CommentRepo {
+	return &CommentRepo{DB: db}
+}
+
+func (r *CommentRepo) Create(comment *Comment) (int64, error) {
+	var parentID interface{}
+	if comment.ParentID != 0 {
+		parentID = comment.ParentID
+	}
+
+	result, err := r.DB.Exec(`
+		INSERT INTO comments (book_id, user_id, parent_id, chapter, body)
+		VALUES (?, ?, ?, ?, ?)
+	`, comment.BookID, comment.UserID, parentID, comment.Chapter, comment.Body)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *CommentRepo) GetByID(id int) (*Comment, error) {
+	c := &Comment{}
+	err := r.DB.QueryRow(`
+		SELECT c.id, c.book_id, c.user_id, u.username, COALESCE(c.parent_id, 0), c.chapter,
+		       c.body, c.hidden, c.deleted, c.created_at, c.updated_at
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.id = ?
+	`, id).Scan(&c.ID, &c.BookID, &c.UserID, &c.Username, &c.ParentID, &c.Chapter,
+		&c.Body, &c.Hidden, &c.Deleted, &c.CreatedAt, &c.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoComment
+	}
+	return c, err
+}
+
+// GetThreadByBook возвращает дерево комментариев книги. chapter < 0 - все
+// комментарии, иначе только обсуждение указанной главы (0 - вся работа).
+func (r *CommentRepo) GetThreadByBook(bookID, chapter int) ([]*Comment, error) {
+	where := "WHERE c.book_id = ?"
+	args := []interface{}{bookID}
+	if chapter >= 0 {
+		where += " AND c.chapter = ?"
+		args = append(args, chapter)
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT c.id, c.book_id, c.user_id, u.username, COALESCE(c.parent_id, 0), c.chapter,
+		       c.body, c.hidden, c.deleted, c.created_at, c.updated_at
+		FROM comments c
+		JOIN users u ON c.user_id = u.id
+		`+where+`
+		ORDER BY c.created_at, c.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*Comment
+	for rows.Next() {
+		c := &Comment{}
+		err := rows.Scan(&c.ID, &c.BookID, &c.UserID, &c.Username, &c.ParentID, &c.Chapter,
+			&c.Body, &c.Hidden, &c.Deleted, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buildCommentTree(all), nil
+}
+
+// buildCommentTree раскладывает плоский список по родителям. Ответы, чей
+// родитель не попал в выборку (другая глава), показываются как корневые.
+func buildCommentTree(all []*Comment) []*Comment {
+	byID := make(map[int]*Comment, len(all))
+	for _, c := range all {
+		byID[c.ID] = c
+	}
+
+	var roots []*Comment
+	for _, c := range all {
+		if parent, ok := byID[c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	var setDepth func(comments []*Comment, depth int)
+	setDepth = func(comments []*Comment, depth int) {
+		for _, c := range comments {
+			c.Depth = depth
+			setDepth(c.Replies, depth+1)
+		}
+	}
+	setDepth(roots, 0)
+
+	return roots
+}
+
+// Walk обходит дерево комментариев в глубину
+func Walk(comments []*Comment, fn func(c *Comment)) {
+	for _, c := range comments {
+		fn(c)
+		Walk(c.Replies, fn)
+	}
+}
+
+func (r *CommentRepo) Update(id, userID int, body string) error {
+	result, err := r.DB.Exec(`
+		UPDATE comments SET body = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND deleted = 0
+	`, body, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoComment
+	}
+	return nil
+}
+
+// Delete удаляет комментарий. Если на него уже ответили, текст стирается,
+// а сам комментарий остается в ветке как удаленный.
+func (r *CommentRepo) Delete(id int) error {
+	var replies int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE parent_id = ?", id).Scan(&replies)
+	if err != nil {
+		return err
+	}
+
+	if replies == 0 {
+		_, err = r.DB.Exec("DELETE FROM comments WHERE id = ?", id)
+		return err
+	}
+
+	_, err = r.DB.Exec(`
+		UPDATE comments SET body = '', deleted = 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id)
+	return err
+}
+
+func (r *CommentRepo) SetHidden(id int, hidden bool) error {
+	_, err := r.DB.Exec("UPDATE comments SET hidden = ? WHERE id = ?", hidden, id)
+	return err
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// MaxFilterTags - ограничение числа скрываемых тегов в личном фильтре
+const MaxFilterTags = 50
+
+// ContentFilter - личный фильтр читателя. Работы с любым из перечисленных
+// рейтингов, предупреждений или тегов не показываются на главной и в поиске.
+type ContentFilter struct {
+	UserID   int
+	Ratings  []string
+	Warnings []string
+	// Tags хранятся как введены и раскрываются в синонимы и дочерние теги
+	// при каждом запросе, поэтому слияние тегов фильтр не ломает
+	Tags []string
+	// Languages, наоборот, оставляет только работы на этих языках;
+	// пустой список - все языки
+	Languages []string
+}
+
+// Empty сообщает, что фильтр ничего не скрывает
+func (f *ContentFilter) Empty() bool {
+	return f == nil || len(f.Ratings)+len(f.Warnings)+len(f.Tags)+len(f.Languages) == 0
+}
+
+func (f *ContentFilter) HidesRating(rating string) bool {
+	return containsString(f.Ratings, rating)
+}
+
+func (f *ContentFilter) HidesWarning(warning string) bool {
+	return containsString(f.Warnings, warning)
+}
+
+func (f *ContentFilter) ShowsLanguage(language string) bool {
+	return containsString(f.Languages, language)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// loadContentFilter читает фильтр пользователя; если он не настроен,
+// возвращается пустой фильтр
+func loadContentFilter(db *sql.DB, userID int) (*ContentFilter, error) {
+	filter := &ContentFilter{UserID: userID}
+	var ratings, warnings, tags, languages string
+	err := db.QueryRow(
+		"SELECT ratings, warnings, tags, languages FROM content_filters WHERE user_id = ?", userID,
+	).Scan(&ratings, &warnings, &tags, &languages)
+	if err == sql.ErrNoRows {
+		return filter, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Ratings = splitList(ratings)
+	filter.Warnings = splitList(warnings)
+	filter.Tags = splitList(tags)
+	filter.Languages = splitList(languages)
+	return filter, nil
+}
+
+// GetContentFilter возвращает личный фильтр пользователя
+func (r *UserRepo) GetContentFilter(userID int) (*ContentFilter, error) {
+	return loadContentFilter(r.DB, userID)
+}
+
+// SaveContentFilter сохраняет фильтр; неизвестные рейтинги, предупреждения
+// и языки отбрасываются, теги нормализуются через SplitTags
+func (r *UserRepo) SaveContentFilter(filter *ContentFilter) error {
+	var ratings, warnings, languages []string
+	for _, rating := range filter.Ratings {
+		if ContentRatingLabel(rating) != "" {
+			ratings = append(ratings, rating)
+		}
+	}
+	for _, warning := range filter.Warnings {
+		if ContentWarningLabel(warning) != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	for _, language := range filter.Languages {
+		if LanguageLabel(language) != "" {
+			languages = append(languages, language)
+		}
+	}
+	tags := SplitTags(strings.Join(filter.Tags, ","))
+	if len(tags) > MaxFilterTags {
+		tags = tags[:MaxFilterTags]
+	}
+
+	_, err := r.DB.Exec(`
+		INSERT INTO content_filters (user_id, ratings, warnings, tags, languages, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			ratings = excluded.ratings,
+			warnings = excluded.warnings,
+			tags = excluded.tags,
+			languages = excluded.languages,
+			updated_at = excluded.updated_at
+	`, filter.UserID, strings.Join(ratings, ","), strings.Join(warnings, ","), strings.Join(tags, ","),
+		strings.Join(languages, ","))
+	return err
+}
+
+// contentFilterCondition строит условие, которому удовлетворяют работы,
+// не скрытые фильтром. Для пустого фильтра возвращается пустая строка.
+func contentFilterCondition(db *sql.DB, filter *ContentFilter) (string, []interface{}, error) {
+	if filter.Empty() {
+		return "", nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+
+	if len(filter.Ratings) > 0 {
+		placeholders := make([]string, len(filter.Ratings))
+		for i, rating := range filter.Ratings {
+			placeholders[i] = "?"
+			args = append(args, rating)
+		}
+		conditions = append(conditions, "COALESCE(b.content_rating, '') NOT IN ("+strings.Join(placeholders, ",")+")")
+	}
+
+	for _, warning := range filter.Warnings {
+		conditions = append(conditions, "(',' || COALESCE(b.warnings, '') || ',') NOT LIKE ?")
+		args = append(args, "%,"+warning+",%")
+	}
+
+	if len(filter.Languages) > 0 {
+		var condition string
+		condition, args = inCondition("b.language", filter.Languages, args)
+		conditions = append(conditions, condition)
+	}
+
+	tagIDs, err := expandTagNames(db, filter.Tags)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tagIDs) > 0 {
+		placeholders := make([]string, len(tagIDs))
+		for i, id := range tagIDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conditions = append(conditions, "b.id NOT IN (SELECT bt.book_id FROM book_tags bt WHERE bt.tag_id IN ("+
+			strings.Join(placeholders, ",")+"))")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", args, nil
+}
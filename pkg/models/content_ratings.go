@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Рейтинги работ по образцу архивов фанфиков
+const (
+	RatingGeneral  = "G"
+	RatingTeen     = "T"
+	RatingMature   = "M"
+	RatingExplicit = "E"
+	// RatingNotRated получают работы, загруженные до появления рейтингов
+	RatingNotRated = "NR"
+)
+
+// Предупреждения о содержании
+const (
+	WarningViolence       = "violence"
+	WarningCharacterDeath = "major_character_death"
+	WarningNonCon         = "non_con"
+	WarningUnderage       = "underage"
+	WarningNone           = "no_warnings"
+	WarningChoseNotToWarn = "choose_not_to_warn"
+)
+
+var (
+	ErrBadRating   = errors.New("choose a content rating")
+	ErrBadWarnings = errors.New("choose content warnings")
+)
+
+// Option - значение перечисления с подписью для форм и фильтров
+type Option struct {
+	Value string
+	Label string
+}
+
+var ContentRatings = []Option{
+	{RatingGeneral, "General Audiences"},
+	{RatingTeen, "Teen And Up Audiences"},
+	{RatingMature, "Mature"},
+	{RatingExplicit, "Explicit"},
+}
+
+var ContentWarnings = []Option{
+	{WarningViolence, "Graphic Depictions Of Violence"},
+	{WarningCharacterDeath, "Major Character Death"},
+	{WarningNonCon, "Rape/Non-Con"},
+	{WarningUnderage, "Underage"},
+	{WarningNone, "No Archive Warnings Apply"},
+	{WarningChoseNotToWarn, "Creator Chose Not To Use Archive Warnings"},
+}
+
+func optionLabel(options []Option, value string) string {
+	for _, o := range options {
+		if o.Value == value {
+			return o.Label
+		}
+	}
+	return ""
+}
+
+// ContentRatingLabel возвращает подпись рейтинга или пустую строку
+func ContentRatingLabel(rating string) string {
+	if rating == RatingNotRated {
+		return "Not Rated"
+	}
+	return optionLabel(ContentRatings, rating)
+}
+
+// ContentWarningLabel возвращает подпись предупреждения или пустую строку
+func ContentWarningLabel(warning string) string {
+	return optionLabel(ContentWarnings, warning)
+}
+
+// ValidRating проверяет рейтинг, выбранный при загрузке
+func ValidRating(rating string) bool {
+	return optionLabel(ContentRatings, rating) != ""
+}
+
+// NormalizeWarnings проверяет выбранные предупреждения и возвращает их
+// в каноническом порядке через запятую. Нужно выбрать хотя бы одно;
+// "no_warnings" нельзя сочетать с конкретными предупреждениями.
+func NormalizeWarnings(values []string) (string, error) {
+	selected := make(map[string]bool, len(values))
+	for _, v := range values {
+		if optionLabel(ContentWarnings, v) == "" {
+			return "", ErrBadWarnings
+		}
+		selected[v] = true
+	}
+	if len(selected) == 0 || (selected[WarningNone] && len(selected) > 1) {
+		return "", ErrBadWarnings
+	}
+
+	var result []string
+	for _, o := range ContentWarnings {
+		if selected[o.Value] {
+			result = append(result, o.Value)
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// RatingLabel возвращает полное название рейтинга работы
+func (b *Book) RatingLabel() string {
+	if label := ContentRatingLabel(b.ContentRating); label != "" {
+		return label
+	}
+	return ContentRatingLabel(RatingNotRated)
+}
+
+// IsExplicit сообщает, нужно ли предупреждение перед чтением
+func (b *Book) IsExplicit() bool {
+	return b.ContentRating == RatingExplicit
+}
+
+// WarningList возвращает предупреждения работы с подписями
+func (b *Book) WarningList() []Option {
+	var list []Option
+	for _, v := range SplitTags(b.Warnings) {
+		if label := optionLabel(ContentWarnings, v); label != "" {
+			list = append(list, Option{v, label})
+		}
+	}
+	return list
+}
+
+// HasWarning сообщает, отмечено ли у работы предупреждение
+func (b *Book) HasWarning(warning string) bool {
+	for _, v := range SplitTags(b.Warnings) {
+		if v == warning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// FacetCount - значение фасета и число книг выдачи с этим значением
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchFacets - агрегаты по всей выдаче поиска для боковой панели
+type SearchFacets struct {
+	Total    int          `json:"total"`
+	Tags     []FacetCount `json:"tags"`
+	Ratings  []FacetCount `json:"ratings"`
+	Warnings []FacetCount `json:"warnings"`
+	Statuses []FacetCount `json:"statuses"`
+	Lengths  []FacetCount `json:"lengths"`
+	// Languages - языки работ выдачи
+	Languages []FacetCount `json:"languages"`
+}
+
+const facetTagLimit = 20
+
+func (r *BookRepo) searchFacets(whereClause string, args []interface{}) (*SearchFacets, error) {
+	facets := &SearchFacets{}
+
+	matching := `SELECT b.id FROM books b JOIN users u ON b.user_id = u.id ` + whereClause
+
+	err := r.DB.QueryRow(`SELECT COUNT(*) FROM (`+matching+`)`, args...).Scan(&facets.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	// Синонимы сворачиваются в канонический тег
+	rows, err := r.DB.Query(`
+		SELECT COALESCE(c.name, t.name) AS tag_name, COUNT(DISTINCT bt.book_id) AS cnt
+		FROM book_tags bt
+		JOIN tags t ON t.id = bt.tag_id
+		LEFT JOIN tags c ON c.id = t.merger_id
+		WHERE bt.book_id IN (`+matching+`)
+		GROUP BY COALESCE(c.id, t.id)
+		ORDER BY cnt DESC, tag_name
+		LIMIT ?
+	`, append(append([]interface{}{}, args...), facetTagLimit)...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Tags, err = scanFacetCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = r.DB.Query(`
+		SELECT b.content_rating, COUNT(*)
+		FROM books b
+		WHERE b.id IN (`+matching+`)
+		GROUP BY b.content_rating
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Ratings, err = scanFacetCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	facets.Ratings = orderFacets(facets.Ratings, ContentRatings)
+
+	rows, err = r.DB.Query(`
+		SELECT b.status, COUNT(*)
+		FROM books b
+		WHERE b.id IN (`+matching+`)
+		GROUP BY b.status
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Statuses, err = scanFacetCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	facets.Statuses = orderFacets(facets.Statuses, WorkStatuses)
+
+	rows, err = r.DB.Query(`
+		SELECT `+lengthBucketExpr+` AS bucket, COUNT(*)
+		FROM books b
+		WHERE b.id IN (`+matching+`)
+		GROUP BY bucket
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Lengths, err = scanFacetCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	facets.Lengths = orderFacets(facets.Lengths, LengthBuckets)
+
+	rows, err = r.DB.Query(`
+		SELECT b.language, COUNT(*)
+		FROM books b
+		WHERE b.id IN (`+matching+`)
+		GROUP BY b.language
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	facets.Languages, err = scanFacetCounts(rows)
+	if err != nil {
+		return nil, err
+	}
+	facets.Languages = orderFacets(facets.Languages, Languages)
+
+	// Предупреждения считаются по каждому значению из списка
+	facets.Warnings = make([]FacetCount, 0, len(ContentWarnings))
+	for _, warning := range ContentWarnings {
+		var count int
+		err := r.DB.QueryRow(`
+			SELECT COUNT(*) FROM books b
+			WHERE b.id IN (`+matching+`) AND (',' || b.warnings || ',') LIKE ?
+		`, append(append([]interface{}{}, args...), "%,"+warning.Value+",%")...).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			facets.Warnings = append(facets.Warnings, FacetCount{Value: warning.Value, Count: count})
+		}
+	}
+	return facets, nil
+}
+
+func scanFacetCounts(rows *sql.Rows) ([]FacetCount, error) {
+	defer rows.Close()
+
+	var counts []FacetCount
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+// orderFacets расставляет значения в порядке перечисления, неизвестные - в конец
+func orderFacets(counts []FacetCount, options []Option) []FacetCount {
+	ordered := make([]FacetCount, 0, len(counts))
+	for _, o := range options {
+		for _, fc := range counts {
+			if fc.Value == o.Value {
+				ordered = append(ordered, fc)
+			}
+		}
+	}
+	for _, fc := range counts {
+		if optionLabel(options, fc.Value) == "" {
+			ordered = append(ordered, fc)
+		}
+	}
+	return ordered
+}
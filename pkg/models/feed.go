@@ -0,0 +1,122 @@
+package models
+
+import (
+	"strings"
+)
+
+// Причины, по которым работа попала в ленту
+const (
+	FeedReasonAuthor = "author"
+	FeedReasonUpdate = "update"
+	FeedReasonTag    = "tag"
+)
+
+// GetFeed возвращает персональную ленту: новые работы отслеживаемых авторов,
+// обновления работ из подписок и новые работы в отслеживаемых тегах. Каждый
+// источник выбирается по своему индексу, поэтому таблица книг целиком не
+// просматривается. Работа попадает в ленту один раз - по самому свежему
+// событию. Новые работы попадают в ленту, только если они в списках;
+// обновления - если работа доступна читателю по ссылке.
+func (r *BookRepo) GetFeed(userID int, page PageRequest) (*BookPage, error) {
+	accessible, accessibleArgs := accessibleCondition(userID)
+	sources := []string{`
+			SELECT b.id, COALESCE(b.published_at, b.created_at), '` + FeedReasonAuthor + `'
+			FROM follows f
+			JOIN books b ON b.user_id = f.author_id
+			WHERE f.follower_id = ? AND ` + listedCondition(userID), `
+			SELECT b.id, b.updated_at, '` + FeedReasonUpdate + `'
+			FROM work_subscriptions s
+			JOIN books b ON b.id = s.book_id
+			WHERE s.user_id = ? AND b.user_id != ? AND b.updated_at > b.created_at
+			  AND ` + accessible,
+	}
+	args := append([]interface{}{userID, userID, userID}, accessibleArgs...)
+
+	tagIDs, err := queryIDs(r.DB, "SELECT tag_id FROM tag_follows WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tagIDs) > 0 {
+		tagIDs, err = expandTagIDs(r.DB, tagIDs)
+		if err != nil {
+			return nil, err
+		}
+		placeholders := make([]string, len(tagIDs))
+		for i, id := range tagIDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		sources = append(sources, `
+			SELECT DISTINCT b.id, COALESCE(b.published_at, b.created_at), '`+FeedReasonTag+`'
+			FROM book_tags bt
+			JOIN books b ON b.id = bt.book_id
+			WHERE bt.tag_id IN (`+strings.Join(placeholders, ",")+`) AND b.user_id != ?
+			  AND `+mutedAuthorsCondition+` AND `+listedCondition(userID))
+		args = append(args, userID, userID)
+	}
+
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	whereClause := ""
+	backward, anchorID := page.anchor()
+	if anchorID != 0 {
+		op := "<"
+		if backward {
+			op = ">"
+		}
+		whereClause = "WHERE (f.activity, f.book_id) " + op +
+			" (SELECT activity, book_id FROM feed WHERE book_id = ?)"
+		args = append(args, anchorID)
+	}
+
+	direction := " DESC"
+	if backward {
+		direction = " ASC"
+	}
+
+	// Для строки с MAX() SQLite берет reason из той же строки, то есть из
+	// самого свежего события
+	sqlQuery := `
+		WITH events(book_id, activity, reason) AS (` + strings.Join(sources, `
+			UNION ALL`) + `
+		),
+		feed AS (
+			SELECT book_id, MAX(activity) AS activity, reason
+			FROM events
+			GROUP BY book_id
+		)
+		SELECT ` + bookColumns + `, f.reason, f.activity
+		FROM feed f
+		JOIN books b ON b.id = f.book_id
+		JOIN users u ON b.user_id = u.id
+		` + whereClause + `
+		ORDER BY f.activity` + direction + `, f.book_id` + direction + `
+		LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := r.DB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		var reason, activity string
+		book, err := scanBook(rows, &reason, &activity)
+		if err != nil {
+			return nil, err
+		}
+		book.FeedReason = reason
+		book.FeedAt = activity
+		books = append(books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newBookPage(books, limit, backward, anchorID != 0), nil
+}
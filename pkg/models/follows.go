@@ -0,0 +1,185 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrSelfFollow = errors.New("you can't follow yourself")
+
+// FollowRepo хранит подписки на авторов и на отдельные работы
+type FollowRepo struct {
+	DB *sql.DB
+}
+
+func NewFollowRepo(db *sql.DB) *FollowRepo {
+	return &FollowRepo{DB: db}
+}
+
+func (r *FollowRepo) Follow(followerID, authorID int) error {
+	if followerID == authorID {
+		return ErrSelfFollow
+	}
+	_, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO follows (follower_id, author_id) VALUES (?, ?)
+	`, followerID, authorID)
+	return err
+}
+
+func (r *FollowRepo) Unfollow(followerID, authorID int) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM follows WHERE follower_id = ? AND author_id = ?", followerID, authorID,
+	)
+	return err
+}
+
+func (r *FollowRepo) IsFollowing(followerID, authorID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM follows WHERE follower_id = ? AND author_id = ?)",
+		followerID, authorID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// FollowerIDs возвращает подписчиков автора
+func (r *FollowRepo) FollowerIDs(authorID int) ([]int, error) {
+	return queryIDs(r.DB, "SELECT follower_id FROM follows WHERE author_id = ?", authorID)
+}
+
+func (r *FollowRepo) CountFollowers(authorID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE author_id = ?", authorID).Scan(&count)
+	return count, err
+}
+
+func (r *FollowRepo) CountFollowing(followerID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE follower_id = ?", followerID).Scan(&count)
+	return count, err
+}
+
+// GetFollowing возвращает авторов, на которых подписан пользователь
+func (r *FollowRepo) GetFollowing(followerID int) ([]*User, error) {
+	rows, err := r.DB.Query(`
+		SELECT u.id, u.username, u.avatar
+		FROM follows f
+		JOIN users u ON f.author_id = u.id
+		WHERE f.follower_id = ?
+		ORDER BY u.username
+	`, followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u := &User{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Avatar); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (r *FollowRepo) Subscribe(userID, bookID int) error {
+	_, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO work_subscriptions (user_id, book_id) VALUES (?, ?)
+	`, userID, bookID)
+	return err
+}
+
+func (r *FollowRepo) Unsubscribe(userID, bookID int) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM work_subscriptions WHERE user_id = ? AND book_id = ?", userID, bookID,
+	)
+	return err
+}
+
+func (r *FollowRepo) IsSubscribed(userID, bookID int) (bool, error) {
+	var exists bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM work_subscriptions WHERE user_id = ? AND book_id = ?)",
+		userID, bookID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// SubscriberIDs возвращает пользователей, подписанных на работу
+func (r *FollowRepo) SubscriberIDs(bookID int) ([]int, error) {
+	return queryIDs(r.DB, "SELECT user_id FROM work_subscriptions WHERE book_id = ?", bookID)
+}
+
+// FollowTag подписывает пользователя на тег; синоним заменяется каноническим
+// тегом, чтобы лента находила работы со всеми вариантами написания
+func (r *FollowRepo) FollowTag(userID int, name string) error {
+	var tagID int
+	err := r.DB.QueryRow(
+		"SELECT COALESCE(merger_id, id) FROM tags WHERE normalized = ?", NormalizeTag(name),
+	).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		return ErrNoTag
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.DB.Exec(`
+		INSERT OR IGNORE INTO tag_follows (user_id, tag_id) VALUES (?, ?)
+	`, userID, tagID)
+	return err
+}
+
+func (r *FollowRepo) UnfollowTag(userID int, name string) error {
+	_, err := r.DB.Exec(`
+		DELETE FROM tag_follows
+		WHERE user_id = ? AND tag_id = (
+			SELECT COALESCE(merger_id, id) FROM tags WHERE normalized = ?
+		)
+	`, userID, NormalizeTag(name))
+	return err
+}
+
+// GetFollowedTags возвращает теги, на которые подписан пользователь
+func (r *FollowRepo) GetFollowedTags(userID int) ([]*Tag, error) {
+	rows, err := r.DB.Query(`
+		SELECT t.id, t.name, t.normalized, t.category
+		FROM tag_follows tf
+		JOIN tags t ON tf.tag_id = t.id
+		WHERE tf.user_id = ?
+		ORDER BY t.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*Tag
+	for rows.Next() {
+		t := &Tag{}
+		if err := rows.Scan(&t.ID, &t.Name, &t.Normalized, &t.Category); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// KudosGiver определяет, кто оставляет кудос: зарегистрированный
+// пользователь или гость, которого различаем по хэшу IP
+type KudosGiver struct {
+	UserID int
+	IPHash string
+}
+
+// HashIP хэширует адрес гостя вместе с солью, чтобы не хранить IP в открытом виде
+func HashIP(ip, salt string) string {
+	sum := sha256.Sum256([]byte(salt + "|" + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+func (g KudosGiver) args() (userID, ipHash interface{}) {
+	if g.UserID != 0 {
+		return g.UserID, nil
+	}
+	return nil, g.IPHash
+}
+
+// GiveKudos добавляет кудос и пересчитывает счетчик книги. Возвращает false,
+// если этот пользователь или гость уже оставлял кудос.
+func (r *BookRepo) GiveKudos(bookID int, giver KudosGiver) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	userID, ipHash := giver.args()
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO kudos (book_id, user_id, ip_hash)
+		VALUES (?, ?, ?)
+	`, bookID, userID, ipHash)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if added == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		UPDATE books
+		SET kudos_count = (
+			SELECT COUNT(*) FROM kudos WHERE book_id = ?
+		)
+		WHERE id = ?
+	`, bookID, bookID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (r *BookRepo) HasKudos(bookID int, giver KudosGiver) (bool, error) {
+	column, value := "user_id", interface{}(giver.UserID)
+	if giver.UserID == 0 {
+		column, value = "ip_hash", giver.IPHash
+	}
+
+	var exists bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM kudos WHERE book_id = ? AND "+column+" = ?)",
+		bookID, value,
+	).Scan(&exists)
+	return exists, err
+}
+
+// GetKudosGivers возвращает имена пользователей, оставивших кудос, и число
+// гостевых кудосов
+func (r *BookRepo) GetKudosGivers(bookID, limit int) ([]string, int, error) {
+	rows, err := r.DB.Query(`
+		SELECT u.username
+		FROM kudos k
+		JOIN users u ON k.user_id = u.id
+		WHERE k.book_id = ?
+		ORDER BY k.created_at DESC, k.id DESC
+		LIMIT ?
+	`, bookID, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, 0, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var guests int
+	err = r.DB.QueryRow(
+		"SELECT COUNT(*) FROM kudos WHERE book_id = ? AND user_id IS NULL", bookID,
+	).Scan(&guests)
+	return names, guests, err
+}
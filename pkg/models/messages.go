@@ -0,0 +1,294 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+const MaxMessageLength = 5000
+
+// Ограничения против спама: новые переписки в час и сообщения в минуту
+const (
+	NewConversationsPerHour = 5
+	MessagesPerMinute       = 20
+)
+
+var (
+	ErrNoConversation = errors.New("conversation not found")
+	ErrSelfMessage    = errors.New("you can't message yourself")
+	ErrMessageBlocked = errors.New("this user does not accept your messages")
+	ErrRateLimited    = errors.New("too many messages, try again later")
+)
+
+// Conversation - переписка двух пользователей так, как ее видит один из них
+type Conversation struct {
+	ID          int    `json:"id"`
+	OtherID     int    `json:"other_id"`
+	OtherName   string `json:"other_name"`
+	OtherAvatar string `json:"other_avatar"`
+	LastMessage string `json:"last_message"`
+	Unread      int    `json:"unread"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type Message struct {
+	ID             int    `json:"id"`
+	ConversationID int    `json:"conversation_id"`
+	SenderID       int    `json:"sender_id"`
+	SenderName     string `json:"sender_name"`
+	Body           string `json:"body"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type MessageRepo struct {
+	DB *sql.DB
+}
+
+func NewMessageRepo(db *sql.DB) *MessageRepo {
+	return &MessageRepo{DB: db}
+}
+
+// Start отправляет сообщение recipientID. Если переписка между ними уже
+// есть, сообщение добавляется в нее, иначе создается новая - с учетом
+// лимита новых переписок в час.
+func (r *MessageRepo) Start(senderID, recipientID int, body string) (int, error) {
+	if senderID == recipientID {
+		return 0, ErrSelfMessage
+	}
+	if err := r.checkAllowed(senderID, recipientID); err != nil {
+		return 0, err
+	}
+
+	var conversationID int
+	err := r.DB.QueryRow(`
+		SELECT a.conversation_id
+		FROM conversation_participants a
+		JOIN conversation_participants b ON b.conversation_id = a.conversation_id AND b.user_id = ?
+		WHERE a.user_id = ?
+		LIMIT 1
+	`, recipientID, senderID).Scan(&conversationID)
+	if err == nil {
+		return conversationID, r.insertMessage(conversationID, senderID, body)
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var recent int
+	err = r.DB.QueryRow(`
+		SELECT COUNT(*) FROM conversations
+		WHERE created_by = ? AND created_at > datetime('now', '-1 hour')
+	`, senderID).Scan(&recent)
+	if err != nil {
+		return 0, err
+	}
+	if recent >= NewConversationsPerHour {
+		return 0, ErrRateLimited
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO conversations (created_by) VALUES (?)", senderID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, userID := range []int{senderID, recipientID} {
+		_, err := tx.Exec(
+			"INSERT INTO conversation_participants (conversation_id, user_id) VALUES (?, ?)", id, userID,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := insertMessage(tx, int(id), senderID, body); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// Reply добавляет сообщение в существующую переписку
+func (r *MessageRepo) Reply(conversationID, senderID int, body string) error {
+	conversation, err := r.Get(conversationID, senderID)
+	if err != nil {
+		return err
+	}
+	if err := r.checkAllowed(senderID, conversation.OtherID); err != nil {
+		return err
+	}
+	return r.insertMessage(conversationID, senderID, body)
+}
+
+// checkAllowed проверяет блокировки в обе стороны и частоту сообщений
+func (r *MessageRepo) checkAllowed(senderID, recipientID int) error {
+	blocked, err := blockedBetween(r.DB, senderID, recipientID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrMessageBlocked
+	}
+
+	var recent int
+	err = r.DB.QueryRow(`
+		SELECT COUNT(*) FROM messages
+		WHERE sender_id = ? AND created_at > datetime('now', '-1 minute')
+	`, senderID).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent >= MessagesPerMinute {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func (r *MessageRepo) insertMessage(conversationID, senderID int, body string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertMessage(tx, conversationID, senderID, body); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertMessage сохраняет сообщение и сразу отмечает его прочитанным для
+// отправителя
+func insertMessage(tx *sql.Tx, conversationID, senderID int, body string) error {
+	result, err := tx.Exec(`
+		INSERT INTO messages (conversation_id, sender_id, body) VALUES (?, ?, ?)
+	`, conversationID, senderID, body)
+	if err != nil {
+		return err
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE conversations SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", conversationID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE conversation_participants SET last_read_id = ?
+		WHERE conversation_id = ? AND user_id = ?
+	`, messageID, conversationID, senderID)
+	return err
+}
+
+const conversationColumns = `c.id, o.user_id, u.username, u.avatar,
+		       COALESCE((SELECT m.body FROM messages m WHERE m.conversation_id = c.id ORDER BY m.id DESC LIMIT 1), ''),
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.conversation_id = c.id AND m.id > p.last_read_id AND m.sender_id != p.user_id),
+		       c.updated_at`
+
+const conversationJoins = `
+		FROM conversation_participants p
+		JOIN conversations c ON c.id = p.conversation_id
+		JOIN conversation_participants o ON o.conversation_id = c.id AND o.user_id != p.user_id
+		JOIN users u ON u.id = o.user_id`
+
+func scanConversation(row rowScanner) (*Conversation, error) {
+	c := &Conversation{}
+	err := row.Scan(&c.ID, &c.OtherID, &c.OtherName, &c.OtherAvatar, &c.LastMessage, &c.Unread, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Inbox возвращает переписки пользователя, свежие сверху
+func (r *MessageRepo) Inbox(userID int) ([]*Conversation, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+conversationColumns+conversationJoins+`
+		WHERE p.user_id = ?
+		ORDER BY c.updated_at DESC, c.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// Get возвращает переписку, если userID в ней участвует
+func (r *MessageRepo) Get(conversationID, userID int) (*Conversation, error) {
+	c, err := scanConversation(r.DB.QueryRow(`
+		SELECT `+conversationColumns+conversationJoins+`
+		WHERE p.user_id = ? AND c.id = ?
+	`, userID, conversationID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoConversation
+	}
+	return c, err
+}
+
+func (r *MessageRepo) GetMessages(conversationID int) ([]*Message, error) {
+	rows, err := r.DB.Query(`
+		SELECT m.id, m.conversation_id, m.sender_id, u.username, m.body, m.created_at
+		FROM messages m
+		JOIN users u ON m.sender_id = u.id
+		WHERE m.conversation_id = ?
+		ORDER BY m.id
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		m := &Message{}
+		err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderName, &m.Body, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// MarkRead отмечает все сообщения переписки прочитанными для userID
+func (r *MessageRepo) MarkRead(conversationID, userID int) error {
+	_, err := r.DB.Exec(`
+		UPDATE conversation_participants
+		SET last_read_id = COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = ?), 0)
+		WHERE conversation_id = ? AND user_id = ?
+	`, conversationID, conversationID, userID)
+	return err
+}
+
+// CountUnread считает непрочитанные сообщения во всех переписках пользователя
+func (r *MessageRepo) CountUnread(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM conversation_participants p
+		JOIN messages m ON m.conversation_id = p.conversation_id AND m.id > p.last_read_id
+		WHERE p.user_id = ? AND m.sender_id != ?
+	`, userID, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,137 @@
+package models
+
+// Способы доставки уведомлений на почту
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryDaily     = "daily"
+	DeliveryWeekly    = "weekly"
+	DeliveryOff       = "off"
+)
+
+// DefaultDelivery применяется к типам, для которых пользователь ничего не
+// выбирал: уведомления приходят только на сайт, письма включаются вручную
+const DefaultDelivery = DeliveryOff
+
+var DeliveryModes = []string{DeliveryImmediate, DeliveryDaily, DeliveryWeekly, DeliveryOff}
+
+func validDelivery(delivery string) bool {
+	for _, mode := range DeliveryModes {
+		if mode == delivery {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPreferences возвращает способ доставки для каждого типа уведомлений
+func (r *NotificationRepo) GetPreferences(userID int) (map[string]string, error) {
+	prefs := make(map[string]string, len(NotificationTypes))
+	for _, t := range NotificationTypes {
+		prefs[t] = DefaultDelivery
+	}
+
+	rows, err := r.DB.Query(
+		"SELECT type, delivery FROM notification_preferences WHERE user_id = ?", userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t, delivery string
+		if err := rows.Scan(&t, &delivery); err != nil {
+			return nil, err
+		}
+		prefs[t] = delivery
+	}
+	return prefs, rows.Err()
+}
+
+// SetPreference меняет способ доставки; неизвестные значения игнорируются
+func (r *NotificationRepo) SetPreference(userID int, notificationType, delivery string) error {
+	if !validDelivery(delivery) {
+		return nil
+	}
+	_, err := r.DB.Exec(`
+		INSERT INTO notification_preferences (user_id, type, delivery) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, type) DO UPDATE SET delivery = excluded.delivery
+	`, userID, notificationType, delivery)
+	return err
+}
+
+// PendingEmailUsers возвращает пользователей, у которых есть непрочитанные и
+// еще не отправленные уведомления с указанным способом доставки
+func (r *NotificationRepo) PendingEmailUsers(delivery string) ([]int, error) {
+	return queryIDs(r.DB, `
+		SELECT DISTINCT n.user_id
+		FROM notifications n
+		LEFT JOIN notification_preferences p ON p.user_id = n.user_id AND p.type = n.type
+		WHERE n.read = 0 AND n.emailed = 0 AND COALESCE(p.delivery, ?) = ?
+	`, DefaultDelivery, delivery)
+}
+
+// PendingEmail возвращает такие уведомления конкретного пользователя
+func (r *NotificationRepo) PendingEmail(userID int, delivery string) ([]*Notification, error) {
+	rows, err := r.DB.Query(`
+		SELECT n.id, n.user_id, n.type, COALESCE(n.actor_id, 0), COALESCE(u.username, ''),
+		       COALESCE(n.book_id, 0), n.message, n.link, n.read, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON n.actor_id = u.id
+		LEFT JOIN notification_preferences p ON p.user_id = n.user_id AND p.type = n.type
+		WHERE n.user_id = ? AND n.read = 0 AND n.emailed = 0 AND COALESCE(p.delivery, ?) = ?
+		ORDER BY n.id
+	`, userID, DefaultDelivery, delivery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []*Notification
+	for rows.Next() {
+		n := &Notification{}
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.ActorName,
+			&n.BookID, &n.Message, &n.Link, &n.Read, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (r *NotificationRepo) MarkEmailed(ids []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.Exec("UPDATE notifications SET emailed = 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DigestDue сообщает, прошло ли с прошлой рассылки данного вида больше period
+// (в формате модификатора SQLite, например "-1 day")
+func (r *NotificationRepo) DigestDue(userID int, delivery, period string) (bool, error) {
+	var due bool
+	err := r.DB.QueryRow(`
+		SELECT NOT EXISTS(
+			SELECT 1 FROM digest_log
+			WHERE user_id = ? AND delivery = ? AND sent_at > datetime('now', ?)
+		)
+	`, userID, delivery, period).Scan(&due)
+	return due, err
+}
+
+func (r *NotificationRepo) MarkDigestSent(userID int, delivery string) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO digest_log (user_id, delivery, sent_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, delivery) DO UPDATE SET sent_at = excluded.sent_at
+	`, userID, delivery)
+	return err
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Типы уведомлений
+const (
+	NotifyNewComment     = "new_comment"
+	NotifyCommentReply   = "comment_reply"
+	NotifyNewWork        = "new_work"
+	NotifyWorkUpdated    = "work_updated"
+	NotifyKudosMilestone = "kudos_milestone"
+	NotifyRating         = "rating"
+	NotifyAuthorInvite   = "author_invite"
+	NotifySavedSearch    = "saved_search"
+)
+
+// NotificationTypes перечисляет типы в порядке показа в настройках
+var NotificationTypes = []string{
+	NotifyNewComment,
+	NotifyCommentReply,
+	NotifyNewWork,
+	NotifyWorkUpdated,
+	NotifyKudosMilestone,
+	NotifyRating,
+	NotifyAuthorInvite,
+	NotifySavedSearch,
+}
+
+const NotificationsPageSize = 30
+
+type Notification struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	Type      string `json:"type"`
+	ActorID   int    `json:"actor_id"`
+	ActorName string `json:"actor_name"`
+	BookID    int    `json:"book_id"`
+	Message   string `json:"message"`
+	Link      string `json:"link"`
+	Read      bool   `json:"read"`
+	CreatedAt string `json:"created_at"`
+}
+
+type NotificationRepo struct {
+	DB *sql.DB
+}
+
+func NewNotificationRepo(db *sql.DB) *NotificationRepo {
+	return &NotificationRepo{DB: db}
+}
+
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// CreateForUsers создает копию уведомления для каждого получателя
+func (r *NotificationRepo) CreateForUsers(n Notification, userIDs []int) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO notifications (user_id, type, actor_id, book_id, message, link)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, userID := range userIDs {
+		_, err := stmt.Exec(userID, n.Type, nullableID(n.ActorID), nullableID(n.BookID), n.Message, n.Link)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetByUserID возвращает уведомления пользователя от новых к старым;
+// beforeID > 0 - только уведомления старше указанного
+func (r *NotificationRepo) GetByUserID(userID, beforeID, limit int) ([]*Notification, error) {
+	where := []string{"n.user_id = ?"}
+	args := []interface{}{userID}
+	if beforeID > 0 {
+		where = append(where, "n.id < ?")
+		args = append(args, beforeID)
+	}
+	args = append(args, limit)
+
+	rows, err := r.DB.Query(`
+		SELECT n.id, n.user_id, n.type, COALESCE(n.actor_id, 0), COALESCE(u.username, ''),
+		       COALESCE(n.book_id, 0), n.message, n.link, n.read, n.created_at
+		FROM notifications n
+		LEFT JOIN users u ON n.actor_id = u.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY n.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		n := &Notification{}
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.ActorName,
+			&n.BookID, &n.Message, &n.Link, &n.Read, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (r *NotificationRepo) GetByID(id, userID int) (*Notification, error) {
+	n := &Notification{}
+	err := r.DB.QueryRow(`
+		SELECT id, user_id, type, COALESCE(actor_id, 0), COALESCE(book_id, 0), message, link, read, created_at
+		FROM notifications
+		WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.BookID, &n.Message, &n.Link,
+		&n.Read, &n.CreatedAt)
+	return n, err
+}
+
+func (r *NotificationRepo) CountUnread(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read = 0", userID,
+	).Scan(&count)
+	return count, err
+}
+
+func (r *NotificationRepo) MarkRead(id, userID int) error {
+	_, err := r.DB.Exec("UPDATE notifications SET read = 1 WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+func (r *NotificationRepo) MarkAllRead(userID int) error {
+	_, err := r.DB.Exec("UPDATE notifications SET read = 1 WHERE user_id = ? AND read = 0", userID)
+	return err
+}
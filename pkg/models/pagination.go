@@ -0,0 +1,86 @@
+package models
+
+import (
+	"strconv"
+)
+
+const DefaultPageSize = 12
+
+// Колонки сортировки книг по убыванию; последней всегда идет b.id
+var bookSortColumns = map[string][]string{
+	"newest":  {"COALESCE(b.published_at, b.created_at)", "b.id"},
+	"rating":  {"b.rating", "b.created_at", "b.id"},
+	"popular": {"b.rating_count", "b.rating", "b.id"},
+	"kudos":   {"b.kudos_count", "b.created_at", "b.id"},
+	"words":   {"b.word_count", "b.created_at", "b.id"},
+}
+
+// PageRequest задает страницу выдачи. Cursor имеет вид "n<id>" для страницы
+// после книги id или "p<id>" для страницы перед ней; пустой курсор - первая страница.
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+type BookPage struct {
+	Books      []*Book
+	NextCursor string
+	PrevCursor string
+	// Facets заполняется только поиском
+	Facets *SearchFacets
+	// Hidden - сколько работ скрыто личным фильтром читателя
+	Hidden int
+}
+
+func (p PageRequest) anchor() (backward bool, id int) {
+	if len(p.Cursor) < 2 {
+		return false, 0
+	}
+	id, err := strconv.Atoi(p.Cursor[1:])
+	if err != nil || id <= 0 {
+		return false, 0
+	}
+	switch p.Cursor[0] {
+	case 'n':
+		return false, id
+	case 'p':
+		return true, id
+	}
+	return false, 0
+}
+
+// newBookPage обрезает лишнюю книгу, запрошенную для проверки следующей
+// страницы, и восстанавливает порядок при движении назад
+func newBookPage(books []*Book, limit int, backward, hasAnchor bool) *BookPage {
+	hasMore := len(books) > limit
+	if hasMore {
+		books = books[:limit]
+	}
+
+	if backward {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+		}
+	}
+
+	page := &BookPage{Books: books}
+	if len(books) == 0 {
+		return page
+	}
+
+	first, last := books[0].ID, books[len(books)-1].ID
+	if backward {
+		if hasMore {
+			page.PrevCursor = "p" + strconv.Itoa(first)
+		}
+		page.NextCursor = "n" + strconv.Itoa(last)
+	} else {
+		if hasAnchor {
+			page.PrevCursor = "p" + strconv.Itoa(first)
+		}
+		if hasMore {
+			page.NextCursor = "n" + strconv.Itoa(last)
+		}
+	}
+	return page
+}
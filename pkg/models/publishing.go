@@ -0,0 +1,124 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TimeLayout - формат дат в базе, совпадает с CURRENT_TIMESTAMP SQLite (UTC)
+const TimeLayout = "2006-01-02 15:04:05"
+
+// publishedAtColumn - время, с которого работа видна читателям. Работы,
+// опубликованные сразу при загрузке, могут не иметь published_at.
+const publishedAtColumn = "COALESCE(b.published_at, b.created_at)"
+
+var (
+	ErrBadPublishTime = errors.New("invalid publication time")
+	ErrPublishInPast  = errors.New("scheduled publication time must be in the future")
+)
+
+// publishedCondition пропускает только опубликованные работы
+const publishedCondition = "b.draft = 0"
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// IsDraft - работу видят только владелец и участники, пока ее не опубликуют
+func (b *Book) IsDraft() bool {
+	return b.Draft
+}
+
+// IsScheduled - черновик с назначенным временем публикации
+func (b *Book) IsScheduled() bool {
+	return b.IsDraft() && b.PublishedAt != ""
+}
+
+// Publish публикует черновик сейчас. Возвращает false, если работа уже
+// опубликована (например, ее только что опубликовал планировщик).
+func (r *BookRepo) Publish(bookID int) (bool, error) {
+	result, err := r.DB.Exec(`
+		UPDATE books SET draft = 0, published_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND draft = 1
+	`, bookID)
+	if err != nil {
+		return false, err
+	}
+	published, err := result.RowsAffected()
+	return published > 0, err
+}
+
+// Schedule назначает черновику время публикации; нулевое время снимает
+// расписание, и работа остается черновиком
+func (r *BookRepo) Schedule(bookID int, at time.Time) error {
+	var publishAt interface{}
+	if !at.IsZero() {
+		if !at.After(time.Now()) {
+			return ErrPublishInPast
+		}
+		publishAt = at.UTC().Format(TimeLayout)
+	}
+	_, err := r.DB.Exec(`
+		UPDATE books SET published_at = ? WHERE id = ? AND draft = 1
+	`, publishAt, bookID)
+	return err
+}
+
+// Unpublish возвращает работу в черновики
+func (r *BookRepo) Unpublish(bookID int) error {
+	_, err := r.DB.Exec(`
+		UPDATE books SET draft = 1, published_at = NULL WHERE id = ?
+	`, bookID)
+	return err
+}
+
+// PublishDue публикует черновики, время публикации которых наступило к now,
+// и возвращает их
+func (r *BookRepo) PublishDue(now time.Time) ([]*Book, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+bookColumns+`
+		FROM books b
+		JOIN users u ON b.user_id = u.id
+		WHERE b.draft = 1 AND b.published_at IS NOT NULL AND b.published_at <= ?
+		ORDER BY b.published_at, b.id
+	`, now.UTC().Format(TimeLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*Book
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Время публикации заменяем фактическим: по нему сохраненные поиски
+	// отличают новые работы, и запланированное время могло уже оказаться
+	// позади отметки читателя, открывшего поиск до срабатывания планировщика
+	var published []*Book
+	for _, book := range due {
+		result, err := r.DB.Exec(`
+			UPDATE books SET draft = 0, published_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND draft = 1
+		`, book.ID)
+		if err != nil {
+			return published, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			book.Draft = false
+			published = append(published, book)
+		}
+	}
+	return published, nil
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrNoRevision = errors.New("revision not found")
+
+// Revision - сохраненная версия текста работы. Номера идут подряд в
+// пределах работы; содержимое хранится в базе, чтобы версию можно было
+// восстановить, даже если файл испорчен.
+type Revision struct {
+	ID          int    `json:"id"`
+	BookID      int    `json:"book_id"`
+	Number      int    `json:"number"`
+	ContentHash string `json:"content_hash"`
+	Size        int64  `json:"size"`
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	Note        string `json:"note"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// ShortHash - начало хеша для показа в истории
+func (rev *Revision) ShortHash() string {
+	if len(rev.ContentHash) > 10 {
+		return rev.ContentHash[:10]
+	}
+	return rev.ContentHash
+}
+
+type RevisionRepo struct {
+	DB *sql.DB
+}
+
+func NewRevisionRepo(db *sql.DB) *RevisionRepo {
+	return &RevisionRepo{DB: db}
+}
+
+// ContentHash - sha256 содержимого в hex
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+const revisionColumns = `r.id, r.book_id, r.number, r.content_hash, r.size, COALESCE(r.user_id, 0),
+		       COALESCE(u.username, ''), r.note, r.created_at`
+
+func scanRevision(row rowScanner) (*Revision, error) {
+	rev := &Revision{}
+	err := row.Scan(&rev.ID, &rev.BookID, &rev.Number, &rev.ContentHash, &rev.Size, &rev.UserID,
+		&rev.Username, &rev.Note, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// GetByBook возвращает версии работы, последние первыми
+func (r *RevisionRepo) GetByBook(bookID int) ([]*Revision, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+revisionColumns+`
+		FROM book_revisions r
+		LEFT JOIN users u ON r.user_id = u.id
+		WHERE r.book_id = ?
+		ORDER BY r.number DESC
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// Get возвращает версию работы с ее содержимым
+func (r *RevisionRepo) Get(bookID, number int) (*Revision, []byte, error) {
+	var content []byte
+	rev := &Revision{}
+	err := r.DB.QueryRow(`
+		SELECT `+revisionColumns+`, r.content
+		FROM book_revisions r
+		LEFT JOIN users u ON r.user_id = u.id
+		WHERE r.book_id = ? AND r.number = ?
+	`, bookID, number).Scan(&rev.ID, &rev.BookID, &rev.Number, &rev.ContentHash, &rev.Size, &rev.UserID,
+		&rev.Username, &rev.Note, &rev.CreatedAt, &content)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNoRevision
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return rev, content, nil
+}
+
+// EnsureBaseline сохраняет текущий текст работы первой версией, если
+// версий еще нет: так работы, загруженные до истории версий, не теряют
+// исходный текст при первой правке
+func (r *RevisionRepo) EnsureBaseline(book *Book, content []byte) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO book_revisions (book_id, number, content_hash, content, size, user_id, note, created_at)
+		SELECT id, 1, ?, ?, ?, user_id, ?, created_at FROM books
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM book_revisions WHERE book_id = ?)
+	`, ContentHash(content), content, len(content), "Original version", book.ID, book.ID)
+	return err
+}
+
+// Add сохраняет новую версию работы. Если текст совпадает с последней
+// версией, ничего не сохраняется и возвращается nil.
+func (r *RevisionRepo) Add(bookID, userID int, content []byte, note string) (*Revision, error) {
+	hash := ContentHash(content)
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var lastHash string
+	var lastNumber int
+	err = tx.QueryRow(`
+		SELECT content_hash, number FROM book_revisions
+		WHERE book_id = ? ORDER BY number DESC LIMIT 1
+	`, bookID).Scan(&lastHash, &lastNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if lastHash == hash {
+		return nil, nil
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO book_revisions (book_id, number, content_hash, content, size, user_id, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, bookID, lastNumber+1, hash, content, len(content), nullableID(userID), note)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Revision{
+		ID:          int(id),
+		BookID:      bookID,
+		Number:      lastNumber + 1,
+		ContentHash: hash,
+		Size:        int64(len(content)),
+		UserID:      userID,
+		Note:        note,
+	}, nil
+}
+
+// Delete удаляет версию, например если ее текст не удалось записать в файл
+func (r *RevisionRepo) Delete(id int) error {
+	_, err := r.DB.Exec("DELETE FROM book_revisions WHERE id = ?", id)
+	return err
+}
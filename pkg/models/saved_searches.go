@@ -0,0 +1,214 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+)
+
+var ErrNoSavedSearch = errors.New("saved search not found")
+
+// SavedSearch - сохраненный поиск. FilterParams хранит его структурные
+// фильтры (рейтинг, предупреждения, статус, длину, язык) строкой запроса.
+type SavedSearch struct {
+	ID           int    `json:"id"`
+	UserID       int    `json:"user_id"`
+	Name         string `json:"name"`
+	Query        string `json:"query"`
+	Tags         string `json:"tags"`
+	SortBy       string `json:"sort"`
+	FilterParams string `json:"filter_params"`
+	EmailDigest  bool   `json:"email_digest"`
+	LastSeenAt   string `json:"last_seen_at"`
+	NewCount     int    `json:"new_count"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// Filter возвращает структурные фильтры сохраненного поиска
+func (s *SavedSearch) Filter() SearchFilter {
+	values, _ := url.ParseQuery(s.FilterParams)
+	return ParseSearchFilter(values)
+}
+
+// URL возвращает адрес страницы поиска с параметрами сохраненного поиска
+func (s *SavedSearch) URL() string {
+	params := s.Filter().Values()
+	if s.Query != "" {
+		params.Set("q", s.Query)
+	}
+	if s.Tags != "" {
+		params.Set("tags", s.Tags)
+	}
+	if s.SortBy != "" {
+		params.Set("sort", s.SortBy)
+	}
+	return "/search?" + params.Encode()
+}
+
+type SavedSearchRepo struct {
+	DB *sql.DB
+}
+
+func NewSavedSearchRepo(db *sql.DB) *SavedSearchRepo {
+	return &SavedSearchRepo{DB: db}
+}
+
+// latestPublishedQuery выбирает время публикации последней опубликованной
+// работы. Новизна считается по публикации, а не по id: черновик получает id
+// при создании, а в выдачу попадает позже.
+const latestPublishedQuery = `(SELECT COALESCE(MAX(` + publishedAtColumn + `), CURRENT_TIMESTAMP)
+	FROM books b WHERE ` + publishedCondition + `)`
+
+// Create сохраняет поиск; новыми считаются работы, опубликованные после сохранения
+func (r *SavedSearchRepo) Create(search *SavedSearch) (int64, error) {
+	result, err := r.DB.Exec(`
+		INSERT INTO saved_searches (user_id, name, query, tags, sort, filter_params, email_digest, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, `+latestPublishedQuery+`)
+	`, search.UserID, search.Name, search.Query, search.Tags, search.SortBy, search.FilterParams, search.EmailDigest)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (r *SavedSearchRepo) GetByID(id, userID int) (*SavedSearch, error) {
+	s := &SavedSearch{}
+	err := r.DB.QueryRow(`
+		SELECT id, user_id, name, query, tags, sort, filter_params, email_digest, COALESCE(last_seen_at, created_at), created_at
+		FROM saved_searches
+		WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(&s.ID, &s.UserID, &s.Name, &s.Query, &s.Tags, &s.SortBy, &s.FilterParams,
+		&s.EmailDigest, &s.LastSeenAt, &s.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSavedSearch
+	}
+	return s, err
+}
+
+func (r *SavedSearchRepo) GetByUserID(userID int) ([]*SavedSearch, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, user_id, name, query, tags, sort, filter_params, email_digest, COALESCE(last_seen_at, created_at), created_at
+		FROM saved_searches
+		WHERE user_id = ?
+		ORDER BY name, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []*SavedSearch
+	for rows.Next() {
+		s := &SavedSearch{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Name, &s.Query, &s.Tags, &s.SortBy, &s.FilterParams,
+			&s.EmailDigest, &s.LastSeenAt, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// MarkSeen запоминает время последней работы, которую пользователь видел в выдаче
+func (r *SavedSearchRepo) MarkSeen(id, userID int) error {
+	_, err := r.DB.Exec(`
+		UPDATE saved_searches
+		SET last_seen_at = `+latestPublishedQuery+`
+		WHERE id = ? AND user_id = ?
+	`, id, userID)
+	return err
+}
+
+func (r *SavedSearchRepo) SetEmailDigest(id, userID int, enabled bool) error {
+	_, err := r.DB.Exec(
+		"UPDATE saved_searches SET email_digest = ? WHERE id = ? AND user_id = ?",
+		enabled, id, userID,
+	)
+	return err
+}
+
+func (r *SavedSearchRepo) Delete(id, userID int) error {
+	result, err := r.DB.Exec("DELETE FROM saved_searches WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoSavedSearch
+	}
+	return nil
+}
+
+// GetDigestSearches возвращает сохраненные поиски с включенной рассылкой.
+// LastSeenAt в результате - время публикации последней работы, о которой
+// пользователь уже знает: из выдачи на сайте или из прошлого письма.
+func (r *SavedSearchRepo) GetDigestSearches() ([]*SavedSearch, error) {
+	return r.querySearches(`
+		SELECT id, user_id, name, query, tags, sort, filter_params, email_digest,
+		       MAX(COALESCE(last_seen_at, created_at), COALESCE(last_digest_at, '')), created_at
+		FROM saved_searches
+		WHERE email_digest = 1
+		ORDER BY user_id, name, id
+	`)
+}
+
+// GetNotifySearches возвращает все сохраненные поиски для уведомлений на
+// сайте. LastSeenAt в результате - время публикации последней работы, о
+// которой пользователь уже знает: из выдачи или из прошлого уведомления.
+func (r *SavedSearchRepo) GetNotifySearches() ([]*SavedSearch, error) {
+	return r.querySearches(`
+		SELECT id, user_id, name, query, tags, sort, filter_params, email_digest,
+		       MAX(COALESCE(last_seen_at, created_at), COALESCE(last_notified_at, '')), created_at
+		FROM saved_searches
+		ORDER BY user_id, id
+	`)
+}
+
+func (r *SavedSearchRepo) querySearches(query string) ([]*SavedSearch, error) {
+	rows, err := r.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []*SavedSearch
+	for rows.Next() {
+		s := &SavedSearch{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Name, &s.Query, &s.Tags, &s.SortBy, &s.FilterParams,
+			&s.EmailDigest, &s.LastSeenAt, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
+
+// MarkDigested запоминает время публикации последней работы, попавшей в письмо
+func (r *SavedSearchRepo) MarkDigested(id int, lastAt string) error {
+	_, err := r.DB.Exec(
+		"UPDATE saved_searches SET last_digest_at = ? WHERE id = ?", lastAt, id,
+	)
+	return err
+}
+
+// MarkNotified запоминает время публикации последней работы, о которой
+// пришло уведомление на сайте
+func (r *SavedSearchRepo) MarkNotified(id int, lastAt string) error {
+	_, err := r.DB.Exec(
+		"UPDATE saved_searches SET last_notified_at = ? WHERE id = ?", lastAt, id,
+	)
+	return err
+}
+
+// DisableEmailDigests выключает рассылку всех сохраненных поисков пользователя
+func (r *SavedSearchRepo) DisableEmailDigests(userID int) error {
+	_, err := r.DB.Exec("UPDATE saved_searches SET email_digest = 0 WHERE user_id = ?", userID)
+	return err
+}
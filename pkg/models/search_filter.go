@@ -0,0 +1,82 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ShowFilteredParam со значением off временно отключает личный фильтр
+const ShowFilteredParam = "filters"
+
+// ParseSearchFilter читает структурные фильтры поиска из параметров
+// запроса: rating - рейтинги через запятую, exclude_warnings - исключаемые
+// предупреждения, status и length - статусы и диапазоны длины, language -
+// языки, with_tags - теги, которые должны быть у работы, filters=off
+// отключает личный фильтр читателя. Неизвестные значения отбрасываются.
+func ParseSearchFilter(values url.Values) SearchFilter {
+	filter := SearchFilter{
+		ShowFiltered: values.Get(ShowFilteredParam) == "off",
+	}
+	for _, rating := range splitValues(values.Get("rating")) {
+		if ContentRatingLabel(rating) != "" {
+			filter.Ratings = append(filter.Ratings, rating)
+		}
+	}
+	for _, warning := range splitValues(values.Get("exclude_warnings")) {
+		if ContentWarningLabel(warning) != "" {
+			filter.ExcludeWarnings = append(filter.ExcludeWarnings, warning)
+		}
+	}
+	for _, status := range splitValues(values.Get("status")) {
+		if WorkStatusLabel(status) != "" {
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	for _, length := range splitValues(values.Get("length")) {
+		if LengthBucketLabel(length) != "" {
+			filter.Lengths = append(filter.Lengths, length)
+		}
+	}
+	for _, language := range splitValues(values.Get("language")) {
+		if LanguageLabel(language) != "" {
+			filter.Languages = append(filter.Languages, language)
+		}
+	}
+	for _, tag := range splitValues(values.Get("with_tags")) {
+		if NormalizeTag(tag) != "" {
+			filter.WithTags = append(filter.WithTags, tag)
+		}
+	}
+	return filter
+}
+
+// Values возвращает фильтр в виде параметров запроса, обратно к
+// ParseSearchFilter
+func (f SearchFilter) Values() url.Values {
+	values := url.Values{}
+	setList := func(param string, list []string) {
+		if len(list) > 0 {
+			values.Set(param, strings.Join(list, ","))
+		}
+	}
+	setList("rating", f.Ratings)
+	setList("exclude_warnings", f.ExcludeWarnings)
+	setList("status", f.Statuses)
+	setList("length", f.Lengths)
+	setList("language", f.Languages)
+	setList("with_tags", f.WithTags)
+	if f.ShowFiltered {
+		values.Set(ShowFilteredParam, "off")
+	}
+	return values
+}
+
+func splitValues(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
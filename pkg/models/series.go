@@ -0,0 +1,343 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrNoSeries           = errors.New("series not found")
+	ErrInvalidSeriesTitle = errors.New("series title is required")
+	ErrNotOwnWork         = errors.New("only your own works can be added to a series")
+)
+
+// Series - цикл работ одного автора с заданным порядком чтения
+type Series struct {
+	ID          int    `json:"id"`
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	BookCount   int    `json:"book_count"`
+	CreatedAt   string `json:"created_at"`
+
+	// Books заполняется только там, где нужен список частей
+	Books []*Book `json:"-"`
+}
+
+// SeriesNav - место работы в цикле для навигации "часть N из M"
+type SeriesNav struct {
+	Series   *Series
+	Position int
+	Prev     *Book
+	Next     *Book
+}
+
+type SeriesRepo struct {
+	DB *sql.DB
+}
+
+func NewSeriesRepo(db *sql.DB) *SeriesRepo {
+	return &SeriesRepo{DB: db}
+}
+
+// seriesColumns выбирает цикл с числом частей, видимых viewerID, - тем же
+// условием, что и GetBooks, чтобы "N частей" не выдавало скрытые работы
+func seriesColumns(viewerID int) (string, []interface{}) {
+	visible, args := visibleToCondition(viewerID)
+	return `s.id, s.user_id, u.username, s.title, s.description,
+		       (SELECT COUNT(*) FROM series_books sc JOIN books b ON sc.book_id = b.id
+		        WHERE sc.series_id = s.id AND ` + visible + `), s.created_at`, args
+}
+
+func scanSeries(row rowScanner, extra ...interface{}) (*Series, error) {
+	s := &Series{}
+	dest := []interface{}{&s.ID, &s.UserID, &s.Username, &s.Title, &s.Description,
+		&s.BookCount, &s.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *SeriesRepo) Create(userID int, title, description string) (int64, error) {
+	if title == "" {
+		return 0, ErrInvalidSeriesTitle
+	}
+
+	result, err := r.DB.Exec(
+		"INSERT INTO series (user_id, title, description) VALUES (?, ?, ?)",
+		userID, title, description,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetByID загружает цикл; BookCount - число частей, видимых viewerID
+func (r *SeriesRepo) GetByID(id, viewerID int) (*Series, error) {
+	columns, args := seriesColumns(viewerID)
+	s, err := scanSeries(r.DB.QueryRow(`
+		SELECT `+columns+`
+		FROM series s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.id = ?
+	`, append(args, id)...))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSeries
+	}
+	return s, err
+}
+
+// GetByUserID возвращает циклы пользователя, последние созданные первыми;
+// части считаются те, что видит viewerID
+func (r *SeriesRepo) GetByUserID(userID, viewerID int) ([]*Series, error) {
+	columns, args := seriesColumns(viewerID)
+	rows, err := r.DB.Query(`
+		SELECT `+columns+`
+		FROM series s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.user_id = ?
+		ORDER BY s.id DESC
+	`, append(args, userID)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Series
+	for rows.Next() {
+		s, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// owned загружает цикл и проверяет владельца
+func (r *SeriesRepo) owned(id, userID int) (*Series, error) {
+	s, err := r.GetByID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID != userID {
+		return nil, ErrNoSeries
+	}
+	return s, nil
+}
+
+func (r *SeriesRepo) Update(id, userID int, title, description string) error {
+	if title == "" {
+		return ErrInvalidSeriesTitle
+	}
+	if _, err := r.owned(id, userID); err != nil {
+		return err
+	}
+
+	_, err := r.DB.Exec(`
+		UPDATE series SET title = ?, description = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, title, description, id)
+	return err
+}
+
+// Delete удаляет цикл; сами работы остаются
+func (r *SeriesRepo) Delete(id, userID int) error {
+	if _, err := r.owned(id, userID); err != nil {
+		return err
+	}
+
+	_, err := r.DB.Exec("DELETE FROM series WHERE id = ?", id)
+	return err
+}
+
+// AddBook добавляет работу автора в конец цикла; повторное добавление
+// ничего не меняет
+func (r *SeriesRepo) AddBook(seriesID, userID, bookID int) error {
+	if _, err := r.owned(seriesID, userID); err != nil {
+		return err
+	}
+
+	var own bool
+	err := r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM books WHERE id = ? AND user_id = ?)", bookID, userID,
+	).Scan(&own)
+	if err != nil {
+		return err
+	}
+	if !own {
+		return ErrNotOwnWork
+	}
+
+	_, err = r.DB.Exec(`
+		INSERT OR IGNORE INTO series_books (series_id, book_id, position)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM series_books WHERE series_id = ?
+	`, seriesID, bookID, seriesID)
+	return err
+}
+
+// RemoveBook убирает работу из цикла; следующие части сдвигает триггер
+// trg_series_books_delete, чтобы номера шли подряд
+func (r *SeriesRepo) RemoveBook(seriesID, userID, bookID int) error {
+	if _, err := r.owned(seriesID, userID); err != nil {
+		return err
+	}
+
+	_, err := r.DB.Exec("DELETE FROM series_books WHERE series_id = ? AND book_id = ?", seriesID, bookID)
+	return err
+}
+
+// MoveBook меняет работу местами с соседней частью: delta -1 - на место
+// раньше, +1 - на место позже. На краях цикла ничего не происходит.
+func (r *SeriesRepo) MoveBook(seriesID, userID, bookID, delta int) error {
+	if _, err := r.owned(seriesID, userID); err != nil {
+		return err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRow(
+		"SELECT position FROM series_books WHERE series_id = ? AND book_id = ?", seriesID, bookID,
+	).Scan(&position)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	target := position + delta
+	result, err := tx.Exec(
+		"UPDATE series_books SET position = ? WHERE series_id = ? AND position = ?",
+		position, seriesID, target,
+	)
+	if err != nil {
+		return err
+	}
+	if swapped, err := result.RowsAffected(); err != nil || swapped == 0 {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE series_books SET position = ? WHERE series_id = ? AND book_id = ?",
+		target, seriesID, bookID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetBooks возвращает видимые viewerID части цикла в порядке чтения
+func (r *SeriesRepo) GetBooks(seriesID, viewerID int) ([]*Book, error) {
+	visible, args := visibleToCondition(viewerID)
+	rows, err := r.DB.Query(`
+		SELECT `+bookColumns+`
+		FROM series_books sb
+		JOIN books b ON sb.book_id = b.id
+		JOIN users u ON b.user_id = u.id
+		WHERE sb.series_id = ? AND `+visible+`
+		ORDER BY sb.position
+	`, append([]interface{}{seriesID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// NavForBook возвращает для каждого цикла, в который входит работа, ее номер
+// и ближайшие соседние части, видимые viewerID. Номер и число частей
+// считаются только по видимым частям; сама работа учитывается всегда, даже
+// если она открыта по ссылке и в списках не видна.
+func (r *SeriesRepo) NavForBook(bookID, viewerID int) ([]*SeriesNav, error) {
+	visible, visibleArgs := visibleToCondition(viewerID)
+	visible = "(" + visible + " OR b.id = ?)"
+	visibleArgs = append(visibleArgs, bookID)
+
+	var args []interface{}
+	args = append(args, visibleArgs...)
+	args = append(args, visibleArgs...)
+	args = append(args, bookID)
+	rows, err := r.DB.Query(`
+		SELECT s.id, s.user_id, u.username, s.title, s.description,
+		       (SELECT COUNT(*) FROM series_books sc JOIN books b ON sc.book_id = b.id
+		        WHERE sc.series_id = s.id AND `+visible+`),
+		       s.created_at,
+		       (SELECT COUNT(*) FROM series_books sc JOIN books b ON sc.book_id = b.id
+		        WHERE sc.series_id = s.id AND sc.position <= sb.position AND `+visible+`),
+		       sb.position
+		FROM series_books sb
+		JOIN series s ON sb.series_id = s.id
+		JOIN users u ON s.user_id = u.id
+		WHERE sb.book_id = ?
+		ORDER BY s.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var navs []*SeriesNav
+	var positions []int
+	for rows.Next() {
+		nav := &SeriesNav{}
+		var position int
+		nav.Series, err = scanSeries(rows, &nav.Position, &position)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		navs = append(navs, nav)
+		positions = append(positions, position)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Соседей ищем по месту в цикле, а не по видимому номеру
+	for i, nav := range navs {
+		if nav.Prev, err = r.neighbour(nav.Series.ID, positions[i], viewerID, "<", "DESC"); err != nil {
+			return nil, err
+		}
+		if nav.Next, err = r.neighbour(nav.Series.ID, positions[i], viewerID, ">", "ASC"); err != nil {
+			return nil, err
+		}
+	}
+	return navs, nil
+}
+
+// neighbour находит ближайшую видимую часть до или после position;
+// неопубликованные части пропускаются
+func (r *SeriesRepo) neighbour(seriesID, position, viewerID int, cmp, order string) (*Book, error) {
+	visible, args := visibleToCondition(viewerID)
+	book, err := scanBook(r.DB.QueryRow(`
+		SELECT `+bookColumns+`
+		FROM series_books sb
+		JOIN books b ON sb.book_id = b.id
+		JOIN users u ON b.user_id = u.id
+		WHERE sb.series_id = ? AND sb.position `+cmp+` ? AND `+visible+`
+		ORDER BY sb.position `+order+`
+		LIMIT 1
+	`, append([]interface{}{seriesID, position}, args...)...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return book, err
+}
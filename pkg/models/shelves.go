@@ -0,0 +1,343 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrNoShelf          = errors.New("shelf not found")
+	ErrShelfNameTaken   = errors.New("shelf with this name already exists")
+	ErrDefaultShelf     = errors.New("default shelves can't be deleted")
+	ErrInvalidShelfName = errors.New("shelf name is required")
+)
+
+// Виды полок. Полки статуса чтения взаимоисключающие: книга может
+// лежать только на одной из них
+const (
+	ShelfReadLater = "read_later"
+	ShelfReading   = "reading"
+	ShelfFinished  = "finished"
+	ShelfDropped   = "dropped"
+	ShelfCustom    = "custom"
+)
+
+const (
+	ShelfPrivate = "private"
+	ShelfPublic  = "public"
+)
+
+// defaultShelves создаются каждому пользователю при первом обращении к полкам
+var defaultShelves = []struct {
+	Kind string
+	Name string
+}{
+	{ShelfReadLater, "Read later"},
+	{ShelfReading, "Reading"},
+	{ShelfFinished, "Finished"},
+	{ShelfDropped, "Dropped"},
+}
+
+type Shelf struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Privacy   string `json:"privacy"`
+	BookCount int    `json:"book_count"`
+	CreatedAt string `json:"created_at"`
+
+	// HasBook и Note заполняются ShelvesForBook
+	HasBook bool   `json:"-"`
+	Note    string `json:"-"`
+}
+
+func (s *Shelf) IsPublic() bool {
+	return s.Privacy == ShelfPublic
+}
+
+func (s *Shelf) IsStatus() bool {
+	return s.Kind != ShelfCustom
+}
+
+// ShelfItem - книга на полке с личной заметкой владельца
+type ShelfItem struct {
+	Book    *Book
+	Note    string
+	AddedAt string
+}
+
+type ShelfRepo struct {
+	DB *sql.DB
+}
+
+func NewShelfRepo(db *sql.DB) *ShelfRepo {
+	return &ShelfRepo{DB: db}
+}
+
+func validPrivacy(privacy string) string {
+	if privacy == ShelfPublic {
+		return ShelfPublic
+	}
+	return ShelfPrivate
+}
+
+// EnsureDefaults создает стандартные полки пользователя, если их еще нет
+func (r *ShelfRepo) EnsureDefaults(userID int) error {
+	for _, shelf := range defaultShelves {
+		_, err := r.DB.Exec(`
+			INSERT INTO shelves (user_id, name, kind, privacy)
+			SELECT ?, ?, ?, ?
+			WHERE NOT EXISTS (SELECT 1 FROM shelves WHERE user_id = ? AND kind = ?)
+		`, userID, shelf.Name, shelf.Kind, ShelfPrivate, userID, shelf.Kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ShelfRepo) Create(userID int, name, privacy string) (int64, error) {
+	if name == "" {
+		return 0, ErrInvalidShelfName
+	}
+
+	result, err := r.DB.Exec(`
+		INSERT OR IGNORE INTO shelves (user_id, name, kind, privacy)
+		VALUES (?, ?, ?, ?)
+	`, userID, name, ShelfCustom, validPrivacy(privacy))
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, ErrShelfNameTaken
+	}
+	return result.LastInsertId()
+}
+
+const shelfColumns = `s.id, s.user_id, u.username, s.name, s.kind, s.privacy,
+		       (SELECT COUNT(*) FROM shelf_books sb WHERE sb.shelf_id = s.id), s.created_at`
+
+func scanShelf(row rowScanner, extra ...interface{}) (*Shelf, error) {
+	s := &Shelf{}
+	dest := []interface{}{&s.ID, &s.UserID, &s.Username, &s.Name, &s.Kind, &s.Privacy,
+		&s.BookCount, &s.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *ShelfRepo) GetByID(id int) (*Shelf, error) {
+	s, err := scanShelf(r.DB.QueryRow(`
+		SELECT `+shelfColumns+`
+		FROM shelves s
+		JOIN users u ON s.user_id = u.id
+		WHERE s.id = ?
+	`, id))
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoShelf
+	}
+	return s, err
+}
+
+// GetByUserID возвращает полки пользователя: сначала стандартные, затем
+// свои по имени. Приватные полки отдаются только при includePrivate.
+func (r *ShelfRepo) GetByUserID(userID int, includePrivate bool) ([]*Shelf, error) {
+	where := "WHERE s.user_id = ?"
+	if !includePrivate {
+		where += " AND s.privacy = '" + ShelfPublic + "'"
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT `+shelfColumns+`
+		FROM shelves s
+		JOIN users u ON s.user_id = u.id
+		`+where+`
+		ORDER BY s.kind = ?, s.id
+	`, userID, ShelfCustom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shelves []*Shelf
+	for rows.Next() {
+		s, err := scanShelf(rows)
+		if err != nil {
+			return nil, err
+		}
+		shelves = append(shelves, s)
+	}
+	return shelves, rows.Err()
+}
+
+// ShelvesForBook возвращает все полки пользователя с отметкой, лежит ли на
+// них книга, и заметкой к ней
+func (r *ShelfRepo) ShelvesForBook(userID, bookID int) ([]*Shelf, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+shelfColumns+`, sb.book_id IS NOT NULL, COALESCE(sb.note, '')
+		FROM shelves s
+		JOIN users u ON s.user_id = u.id
+		LEFT JOIN shelf_books sb ON sb.shelf_id = s.id AND sb.book_id = ?
+		WHERE s.user_id = ?
+		ORDER BY s.kind = ?, s.id
+	`, bookID, userID, ShelfCustom)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shelves []*Shelf
+	for rows.Next() {
+		var hasBook bool
+		var note string
+		s, err := scanShelf(rows, &hasBook, &note)
+		if err != nil {
+			return nil, err
+		}
+		s.HasBook, s.Note = hasBook, note
+		shelves = append(shelves, s)
+	}
+	return shelves, rows.Err()
+}
+
+// Update переименовывает полку и меняет приватность; стандартные полки
+// сохраняют свое имя
+func (r *ShelfRepo) Update(id, userID int, name, privacy string) error {
+	shelf, err := r.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if shelf.UserID != userID {
+		return ErrNoShelf
+	}
+	if shelf.IsStatus() || name == "" {
+		name = shelf.Name
+	}
+
+	var taken bool
+	err = r.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM shelves WHERE user_id = ? AND name = ? AND id != ?)",
+		userID, name, id,
+	).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrShelfNameTaken
+	}
+
+	_, err = r.DB.Exec(`
+		UPDATE shelves SET name = ?, privacy = ? WHERE id = ?
+	`, name, validPrivacy(privacy), id)
+	return err
+}
+
+func (r *ShelfRepo) Delete(id, userID int) error {
+	shelf, err := r.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if shelf.UserID != userID {
+		return ErrNoShelf
+	}
+	if shelf.IsStatus() {
+		return ErrDefaultShelf
+	}
+
+	_, err = r.DB.Exec("DELETE FROM shelves WHERE id = ?", id)
+	return err
+}
+
+// AddBook кладет книгу на полку или обновляет заметку, если она уже там.
+// Книга, положенная на полку статуса, снимается с остальных полок статуса.
+func (r *ShelfRepo) AddBook(shelfID, userID, bookID int, note string) error {
+	shelf, err := r.GetByID(shelfID)
+	if err != nil {
+		return err
+	}
+	if shelf.UserID != userID {
+		return ErrNoShelf
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if shelf.IsStatus() {
+		_, err = tx.Exec(`
+			DELETE FROM shelf_books
+			WHERE book_id = ? AND shelf_id IN (
+				SELECT id FROM shelves WHERE user_id = ? AND kind != ? AND id != ?
+			)
+		`, bookID, userID, ShelfCustom, shelfID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO shelf_books (shelf_id, book_id, note) VALUES (?, ?, ?)
+		ON CONFLICT (shelf_id, book_id) DO UPDATE SET note = excluded.note
+	`, shelfID, bookID, note)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *ShelfRepo) RemoveBook(shelfID, userID, bookID int) error {
+	_, err := r.DB.Exec(`
+		DELETE FROM shelf_books
+		WHERE shelf_id = ? AND book_id = ?
+		  AND shelf_id IN (SELECT id FROM shelves WHERE user_id = ?)
+	`, shelfID, bookID, userID)
+	return err
+}
+
+// GetItems возвращает книги полки, последние добавленные первыми. Работы,
+// которые viewerID не может открыть (например, ставшие приватными),
+// не показываются. Работы вне списков видит только владелец полки: для
+// остальных полка не должна становиться списком работ, доступных лишь
+// по ссылке.
+func (r *ShelfRepo) GetItems(shelfID, viewerID int) ([]*ShelfItem, error) {
+	accessible, args := accessibleCondition(viewerID)
+	visible, visibleArgs := visibleToCondition(viewerID)
+	args = append([]interface{}{shelfID, viewerID}, args...)
+	args = append(args, visibleArgs...)
+	rows, err := r.DB.Query(`
+		SELECT `+bookColumns+`, sb.note, sb.added_at
+		FROM shelf_books sb
+		JOIN shelves sh ON sb.shelf_id = sh.id
+		JOIN books b ON sb.book_id = b.id
+		JOIN users u ON b.user_id = u.id
+		WHERE sb.shelf_id = ? AND ((sh.user_id = ? AND `+accessible+`) OR `+visible+`)
+		ORDER BY sb.added_at DESC, b.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*ShelfItem{}
+	for rows.Next() {
+		item := &ShelfItem{}
+		item.Book, err = scanBook(rows, &item.Note, &item.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,503 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Категории тегов
+const (
+	TagCategoryFandom       = "fandom"
+	TagCategoryCharacter    = "character"
+	TagCategoryRelationship = "relationship"
+	TagCategoryFreeform     = "freeform"
+)
+
+var TagCategories = []string{
+	TagCategoryFandom,
+	TagCategoryCharacter,
+	TagCategoryRelationship,
+	TagCategoryFreeform,
+}
+
+var (
+	ErrNoTag        = errors.New("tag not found")
+	ErrTagSelfMerge = errors.New("tag cannot be merged into itself")
+	ErrTagCycle     = errors.New("tag hierarchy cycle")
+)
+
+type Tag struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Normalized string   `json:"normalized,omitempty"`
+	Category   string   `json:"category"`
+	Canonical  bool     `json:"canonical"`
+	MergerID   int      `json:"merger_id,omitempty"`
+	MergerName string   `json:"merger_name,omitempty"`
+	UsageCount int      `json:"usage_count"`
+	Synonyms   []string `json:"synonyms,omitempty"`
+	Parents    []*Tag   `json:"parents,omitempty"`
+}
+
+type TagRepo struct {
+	DB *sql.DB
+}
+
+func NewTagRepo(db *sql.DB) *TagRepo {
+	return &TagRepo{DB: db}
+}
+
+// NormalizeTag приводит тег к виду, по которому сравниваются синонимы:
+// нижний регистр, без лишних пробелов и ведущей решетки
+func NormalizeTag(name string) string {
+	name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// SplitTags разбирает строку тегов через запятую, отбрасывая пустые и повторы
+func SplitTags(tags string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.Join(strings.Fields(tag), " ")
+		norm := NormalizeTag(tag)
+		if norm == "" || seen[norm] {
+			continue
+		}
+		seen[norm] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+func validCategory(category string) bool {
+	for _, c := range TagCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// FindOrCreate возвращает ID тега по имени, создавая его при необходимости
+func (r *TagRepo) FindOrCreate(name string) (int, error) {
+	return findOrCreateTag(r.DB, name)
+}
+
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func findOrCreateTag(db execQuerier, name string) (int, error) {
+	norm := NormalizeTag(name)
+	if norm == "" {
+		return 0, ErrNoTag
+	}
+
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO tags (name, normalized) VALUES (?, ?)",
+		strings.Join(strings.Fields(name), " "), norm,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = db.QueryRow("SELECT id FROM tags WHERE normalized = ?", norm).Scan(&id)
+	return id, err
+}
+
+// SetBookTags синхронизирует связи книги с тегами по строке тегов книги
+func (r *TagRepo) SetBookTags(bookID int, tags string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM book_tags WHERE book_id = ?", bookID)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range SplitTags(tags) {
+		tagID, err := findOrCreateTag(tx, name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT OR IGNORE INTO book_tags (book_id, tag_id) VALUES (?, ?)",
+			bookID, tagID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TagUsageCountQuery пересчитывает usage_count тега из внешнего запроса по
+// tags: сколько работ в открытых списках им отмечено. Используется и в
+// триггерах, поэтому экспортируется.
+var TagUsageCountQuery = `(SELECT COUNT(*) FROM book_tags bt JOIN books b ON b.id = bt.book_id
+			WHERE bt.tag_id = tags.id AND ` + listedCondition(0) + `)`
+
+// ReindexBooks заполняет book_tags для книг, загруженных до появления таблицы тегов
+func (r *TagRepo) ReindexBooks() error {
+	rows, err := r.DB.Query(`
+		SELECT b.id, b.tags FROM books b
+		WHERE b.tags != ''
+		  AND NOT EXISTS (SELECT 1 FROM book_tags bt WHERE bt.book_id = b.id)
+	`)
+	if err != nil {
+		return err
+	}
+
+	type bookTags struct {
+		id   int
+		tags string
+	}
+	var pending []bookTags
+	for rows.Next() {
+		var bt bookTags
+		if err := rows.Scan(&bt.id, &bt.tags); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, bt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, bt := range pending {
+		if err := r.SetBookTags(bt.id, bt.tags); err != nil {
+			return err
+		}
+	}
+
+	// Пересчитываем счетчики для тегов, проиндексированных до появления
+	// триггеров или посчитанных прежними триггерами по всем работам
+	_, err = r.DB.Exec("UPDATE tags SET usage_count = " + TagUsageCountQuery)
+	return err
+}
+
+func (r *TagRepo) GetByID(id int) (*Tag, error) {
+	tag := &Tag{}
+	err := r.DB.QueryRow(`
+		SELECT t.id, t.name, t.normalized, t.category, t.canonical,
+		       COALESCE(t.merger_id, 0), COALESCE(m.name, '')
+		FROM tags t
+		LEFT JOIN tags m ON t.merger_id = m.id
+		WHERE t.id = ?
+	`, id).Scan(&tag.ID, &tag.Name, &tag.Normalized, &tag.Category, &tag.Canonical,
+		&tag.MergerID, &tag.MergerName)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTag
+	}
+	return tag, err
+}
+
+func (r *TagRepo) GetByName(name string) (*Tag, error) {
+	var id int
+	err := r.DB.QueryRow("SELECT id FROM tags WHERE normalized = ?", NormalizeTag(name)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTag
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(id)
+}
+
+// List возвращает теги для страницы модерации вместе с синонимами и родителями
+func (r *TagRepo) List(query string) ([]*Tag, error) {
+	var where string
+	var args []interface{}
+	if query != "" {
+		where = "WHERE t.normalized LIKE ?"
+		args = append(args, "%"+NormalizeTag(query)+"%")
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT t.id, t.name, t.normalized, t.category, t.canonical,
+		       COALESCE(t.merger_id, 0), COALESCE(m.name, ''), t.usage_count
+		FROM tags t
+		LEFT JOIN tags m ON t.merger_id = m.id
+		`+where+`
+		ORDER BY t.canonical DESC, t.usage_count DESC, t.normalized
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []*Tag
+	byID := map[int]*Tag{}
+	for rows.Next() {
+		tag := &Tag{}
+		err := rows.Scan(&tag.ID, &tag.Name, &tag.Normalized, &tag.Category, &tag.Canonical,
+			&tag.MergerID, &tag.MergerName, &tag.UsageCount)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tags = append(tags, tag)
+		byID[tag.ID] = tag
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	synRows, err := r.DB.Query("SELECT merger_id, name FROM tags WHERE merger_id IS NOT NULL ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	for synRows.Next() {
+		var mergerID int
+		var name string
+		if err := synRows.Scan(&mergerID, &name); err != nil {
+			synRows.Close()
+			return nil, err
+		}
+		if tag, ok := byID[mergerID]; ok {
+			tag.Synonyms = append(tag.Synonyms, name)
+		}
+	}
+	synRows.Close()
+
+	parentRows, err := r.DB.Query(`
+		SELECT tp.child_id, p.id, p.name
+		FROM tag_parents tp
+		JOIN tags p ON tp.parent_id = p.id
+		ORDER BY p.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer parentRows.Close()
+	for parentRows.Next() {
+		var childID int
+		parent := &Tag{}
+		if err := parentRows.Scan(&childID, &parent.ID, &parent.Name); err != nil {
+			return nil, err
+		}
+		if tag, ok := byID[childID]; ok {
+			tag.Parents = append(tag.Parents, parent)
+		}
+	}
+
+	return tags, nil
+}
+
+// Autocomplete ищет теги по префиксу нормализованного имени. Синонимы
+// заменяются каноническим тегом, популярность считается по всей группе.
+// Теги категории category поднимаются наверх. usage_count учитывает только
+// работы в открытых списках, чтобы подсказки не выдавали чужие черновики
+// и приватные работы, поэтому тег без таких работ предлагается, только
+// если он канонический.
+func (r *TagRepo) Autocomplete(prefix, category string, limit int) ([]*Tag, error) {
+	prefix = NormalizeTag(prefix)
+	if prefix == "" {
+		return []*Tag{}, nil
+	}
+
+	// Диапазон по индексу вместо LIKE: LIKE в SQLite регистронезависим
+	// только для ASCII и не использует индекс
+	rows, err := r.DB.Query(`
+		SELECT id, name, category, canonical, uses FROM (
+			SELECT c.id, c.name, c.normalized, c.category, c.canonical,
+			       (SELECT SUM(s.usage_count) FROM tags s WHERE s.id = c.id OR s.merger_id = c.id) AS uses
+			FROM tags c
+			WHERE c.id IN (
+				SELECT COALESCE(t.merger_id, t.id) FROM tags t
+				WHERE t.normalized >= ? AND t.normalized < ?
+			)
+		)
+		WHERE canonical = 1 OR uses > 0
+		ORDER BY category = ? DESC, uses DESC, normalized
+		LIMIT ?
+	`, prefix, prefix+"\U0010FFFF", category, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		tag := &Tag{}
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Category, &tag.Canonical, &tag.UsageCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Merge делает тег синонимом канонического тега. Синонимы и дочерние теги
+// объединяемого тега переходят к каноническому.
+func (r *TagRepo) Merge(synonymID, canonicalID int) error {
+	if synonymID == canonicalID {
+		return ErrTagSelfMerge
+	}
+
+	canonical, err := r.GetByID(canonicalID)
+	if err != nil {
+		return err
+	}
+	// Если цель сама синоним, сливаем в ее канонический тег
+	if canonical.MergerID != 0 {
+		canonicalID = canonical.MergerID
+		if synonymID == canonicalID {
+			return ErrTagSelfMerge
+		}
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE tags SET canonical = 1, merger_id = NULL WHERE id = ?", []interface{}{canonicalID}},
+		{"UPDATE tags SET canonical = 0, merger_id = ? WHERE id = ?", []interface{}{canonicalID, synonymID}},
+		{"UPDATE tags SET merger_id = ? WHERE merger_id = ?", []interface{}{canonicalID, synonymID}},
+		{"UPDATE OR IGNORE tag_parents SET child_id = ? WHERE child_id = ?", []interface{}{canonicalID, synonymID}},
+		{"UPDATE OR IGNORE tag_parents SET parent_id = ? WHERE parent_id = ?", []interface{}{canonicalID, synonymID}},
+		{"DELETE FROM tag_parents WHERE child_id = ? OR parent_id = ?", []interface{}{synonymID, synonymID}},
+		{"DELETE FROM tag_parents WHERE child_id = parent_id", nil},
+	}
+	for _, st := range statements {
+		if _, err := tx.Exec(st.query, st.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Unmerge отделяет синоним от канонического тега
+func (r *TagRepo) Unmerge(tagID int) error {
+	_, err := r.DB.Exec("UPDATE tags SET merger_id = NULL WHERE id = ?", tagID)
+	return err
+}
+
+func (r *TagRepo) Update(tagID int, category string, canonical bool) error {
+	if !validCategory(category) {
+		category = TagCategoryFreeform
+	}
+	_, err := r.DB.Exec(
+		"UPDATE tags SET category = ?, canonical = ? WHERE id = ?",
+		category, canonical, tagID,
+	)
+	return err
+}
+
+// AddParent привязывает тег к родителю, например персонажа к фандому
+func (r *TagRepo) AddParent(childID, parentID int) error {
+	if childID == parentID {
+		return ErrTagCycle
+	}
+
+	// Родитель не должен быть потомком дочернего тега
+	descendants, err := expandTagIDs(r.DB, []int{childID})
+	if err != nil {
+		return err
+	}
+	for _, id := range descendants {
+		if id == parentID {
+			return ErrTagCycle
+		}
+	}
+
+	_, err = r.DB.Exec(
+		"INSERT OR IGNORE INTO tag_parents (parent_id, child_id) VALUES (?, ?)",
+		parentID, childID,
+	)
+	return err
+}
+
+func (r *TagRepo) RemoveParent(childID, parentID int) error {
+	_, err := r.DB.Exec(
+		"DELETE FROM tag_parents WHERE parent_id = ? AND child_id = ?",
+		parentID, childID,
+	)
+	return err
+}
+
+// ExpandNames раскрывает теги по именам в ID всех синонимов и потомков
+func (r *TagRepo) ExpandNames(names []string) ([]int, error) {
+	return expandTagNames(r.DB, names)
+}
+
+func expandTagNames(db *sql.DB, names []string) ([]int, error) {
+	var ids []int
+	for _, name := range names {
+		norm := NormalizeTag(name)
+		if norm == "" {
+			continue
+		}
+		var id int
+		err := db.QueryRow(
+			"SELECT COALESCE(merger_id, id) FROM tags WHERE normalized = ?", norm,
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return expandTagIDs(db, ids)
+}
+
+// expandTagIDs возвращает сами теги, все их потомки и синонимы каждого из них
+func expandTagIDs(db *sql.DB, ids []int) ([]int, error) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := db.Query(`
+		WITH RECURSIVE tree(id) AS (
+			SELECT id FROM tags WHERE id IN (`+strings.Join(placeholders, ",")+`)
+			UNION
+			SELECT COALESCE(t.merger_id, t.id)
+			FROM tag_parents tp
+			JOIN tree ON tp.parent_id = tree.id
+			JOIN tags t ON t.id = tp.child_id
+		)
+		SELECT id FROM tree
+		UNION
+		SELECT t.id FROM tags t JOIN tree ON t.merger_id = tree.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
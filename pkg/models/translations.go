@@ -0,0 +1,202 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Языки работ - коды ISO 639-1
+const (
+	LanguageRussian   = "ru"
+	LanguageEnglish   = "en"
+	LanguageUkrainian = "uk"
+	LanguageGerman    = "de"
+	LanguageFrench    = "fr"
+	LanguageSpanish   = "es"
+	LanguageItalian   = "it"
+	LanguagePortugese = "pt"
+	LanguagePolish    = "pl"
+	LanguageJapanese  = "ja"
+	LanguageChinese   = "zh"
+	LanguageKorean    = "ko"
+	LanguageOther     = "other"
+)
+
+// DefaultLanguage получают работы, загруженные до появления поля языка
+const DefaultLanguage = LanguageRussian
+
+var Languages = []Option{
+	{LanguageRussian, "Русский"},
+	{LanguageEnglish, "English"},
+	{LanguageUkrainian, "Українська"},
+	{LanguageGerman, "Deutsch"},
+	{LanguageFrench, "Français"},
+	{LanguageSpanish, "Español"},
+	{LanguageItalian, "Italiano"},
+	{LanguagePortugese, "Português"},
+	{LanguagePolish, "Polski"},
+	{LanguageJapanese, "日本語"},
+	{LanguageChinese, "中文"},
+	{LanguageKorean, "한국어"},
+	{LanguageOther, "Other"},
+}
+
+// LanguageLabel возвращает название языка или пустую строку
+func LanguageLabel(code string) string {
+	return optionLabel(Languages, code)
+}
+
+func (b *Book) LanguageLabel() string {
+	return LanguageLabel(b.Language)
+}
+
+// Разрешение автора оригинала на перевод
+const (
+	PermissionGranted     = "granted"
+	PermissionRequested   = "requested"
+	PermissionNotRequired = "not_required"
+	PermissionNotAsked    = "not_asked"
+)
+
+var TranslationPermissions = []Option{
+	{PermissionGranted, "Permission Granted"},
+	{PermissionRequested, "Permission Requested"},
+	{PermissionNotRequired, "Not Required (Open License)"},
+	{PermissionNotAsked, "Not Asked"},
+}
+
+// TranslationPermissionLabel возвращает подпись статуса разрешения или пустую строку
+func TranslationPermissionLabel(permission string) string {
+	return optionLabel(TranslationPermissions, permission)
+}
+
+var (
+	ErrBadLanguage   = errors.New("unknown language")
+	ErrBadPermission = errors.New("unknown translation permission status")
+	ErrBadOriginal   = errors.New("a translation needs an original work on the site or an original title")
+)
+
+// Translation связывает перевод с оригиналом. Оригинал либо опубликован
+// на сайте (OriginalBookID), либо описан названием, автором и ссылкой.
+type Translation struct {
+	BookID         int    `json:"book_id"`
+	OriginalBookID int    `json:"original_book_id"`
+	OriginalTitle  string `json:"original_title"`
+	OriginalAuthor string `json:"original_author"`
+	OriginalURL    string `json:"original_url"`
+	Permission     string `json:"permission"`
+
+	// Original заполняется, если оригинал есть на сайте
+	Original *Book `json:"-"`
+}
+
+func (t *Translation) PermissionLabel() string {
+	return TranslationPermissionLabel(t.Permission)
+}
+
+// GetTranslation возвращает сведения об оригинале работы или nil, если
+// работа не перевод
+func (r *BookRepo) GetTranslation(bookID int) (*Translation, error) {
+	t := &Translation{BookID: bookID}
+	var originalID sql.NullInt64
+	err := r.DB.QueryRow(`
+		SELECT original_book_id, original_title, original_author, original_url, permission
+		FROM translations
+		WHERE book_id = ?
+	`, bookID).Scan(&originalID, &t.OriginalTitle, &t.OriginalAuthor, &t.OriginalURL, &t.Permission)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if originalID.Valid {
+		t.OriginalBookID = int(originalID.Int64)
+		t.Original, err = r.GetByID(t.OriginalBookID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SaveTranslation отмечает работу t.BookID как перевод. Если оригинал
+// есть на сайте, пустые название и автор берутся из него.
+func (r *BookRepo) SaveTranslation(t *Translation) error {
+	if TranslationPermissionLabel(t.Permission) == "" {
+		return ErrBadPermission
+	}
+	t.OriginalTitle = strings.TrimSpace(t.OriginalTitle)
+	t.OriginalAuthor = strings.TrimSpace(t.OriginalAuthor)
+	t.OriginalURL = strings.TrimSpace(t.OriginalURL)
+
+	if t.OriginalBookID != 0 {
+		if t.OriginalBookID == t.BookID {
+			return ErrBadOriginal
+		}
+		original, err := r.GetByID(t.OriginalBookID)
+		if err != nil {
+			return err
+		}
+		if original == nil {
+			return ErrBadOriginal
+		}
+		if t.OriginalTitle == "" {
+			t.OriginalTitle = original.Title
+		}
+		if t.OriginalAuthor == "" {
+			t.OriginalAuthor = original.Author
+		}
+	}
+	if t.OriginalTitle == "" {
+		return ErrBadOriginal
+	}
+
+	_, err := r.DB.Exec(`
+		INSERT INTO translations (book_id, original_book_id, original_title, original_author, original_url, permission)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (book_id) DO UPDATE SET
+			original_book_id = excluded.original_book_id,
+			original_title = excluded.original_title,
+			original_author = excluded.original_author,
+			original_url = excluded.original_url,
+			permission = excluded.permission
+	`, t.BookID, nullableID(t.OriginalBookID), t.OriginalTitle, t.OriginalAuthor, t.OriginalURL, t.Permission)
+	return err
+}
+
+// DeleteTranslation снимает с работы отметку перевода
+func (r *BookRepo) DeleteTranslation(bookID int) error {
+	_, err := r.DB.Exec("DELETE FROM translations WHERE book_id = ?", bookID)
+	return err
+}
+
+// GetTranslations возвращает переводы работы, опубликованные на сайте и
+// видимые viewerID, сгруппированные по языку
+func (r *BookRepo) GetTranslations(originalID, viewerID int) ([]*Book, error) {
+	visible, args := visibleToCondition(viewerID)
+	rows, err := r.DB.Query(`
+		SELECT `+bookColumns+`
+		FROM translations t
+		JOIN books b ON t.book_id = b.id
+		JOIN users u ON b.user_id = u.id
+		WHERE t.original_book_id = ? AND `+visible+`
+		ORDER BY b.language, b.id
+	`, append([]interface{}{originalID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
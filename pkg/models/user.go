@@ -11,6 +11,25 @@ type User struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Avatar   string `json:"avatar"`
+	Role     string `json:"role"`
+	Bio      string `json:"bio"`
+
+	// ViewAdult - пользователь подтвердил возраст и не видит предупреждения
+	// перед работами с рейтингом Explicit
+	ViewAdult bool `json:"view_adult"`
+}
+
+// MaxBioLength - ограничение длины описания профиля в символах
+const MaxBioLength = 2000
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+)
+
+// IsModerator сообщает, может ли пользователь управлять тегами
+func (u *User) IsModerator() bool {
+	return u != nil && u.Role == RoleModerator
 }
 
 type UserRepo struct {
@@ -37,9 +56,9 @@ func (r *UserRepo) Create(user *User) error {
 func (r *UserRepo) GetByUsername(username string) (*User, error) {
 	user := &User{}
 	err := r.DB.QueryRow(
-		"SELECT id, username, email, password, avatar FROM users WHERE username = ?",
+		"SELECT id, username, email, password, avatar, bio FROM users WHERE username = ?",
 		username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Avatar, &user.Bio)
 	
 	if err == sql.ErrNoRows {
 		return nil, ErrNoUser
@@ -50,9 +69,9 @@ func (r *UserRepo) GetByUsername(username string) (*User, error) {
 func (r *UserRepo) GetByID(id int) (*User, error) {
 	user := &User{}
 	err := r.DB.QueryRow(
-		"SELECT id, username, email, avatar FROM users WHERE id = ?",
+		"SELECT id, username, email, avatar, role, bio, view_adult FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Avatar)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Avatar, &user.Role, &user.Bio, &user.ViewAdult)
 	
 	if err == sql.ErrNoRows {
 		return nil, ErrNoUser
@@ -60,6 +79,49 @@ func (r *UserRepo) GetByID(id int) (*User, error) {
 	return user, err
 }
 
+// SetRole назначает пользователю роль по имени
+func (r *UserRepo) SetRole(username, role string) error {
+	result, err := r.DB.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNoUser
+	}
+	return err
+}
+
+// RevokeModerator возвращает модератору обычную роль
+func (r *UserRepo) RevokeModerator(userID int) error {
+	_, err := r.DB.Exec(
+		"UPDATE users SET role = ? WHERE id = ? AND role = ?",
+		RoleUser, userID, RoleModerator,
+	)
+	return err
+}
+
+// GetModerators возвращает всех модераторов по имени
+func (r *UserRepo) GetModerators() ([]*User, error) {
+	rows, err := r.DB.Query(
+		"SELECT id, username, role FROM users WHERE role = ? ORDER BY username",
+		RoleModerator,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
 func (r *UserRepo) Authorize(username, password string) (*User, error) {
 	user, err := r.GetByUsername(username)
 	if err != nil {
@@ -106,6 +168,22 @@ func (r *UserRepo) UpdateAvatar(userID int, avatarPath string) error {
 	return err
 }
 
+func (r *UserRepo) UpdateBio(userID int, bio string) error {
+	_, err := r.DB.Exec(
+		"UPDATE users SET bio = ? WHERE id = ?",
+		bio, userID,
+	)
+	return err
+}
+
+func (r *UserRepo) SetViewAdult(userID int, viewAdult bool) error {
+	_, err := r.DB.Exec(
+		"UPDATE users SET view_adult = ? WHERE id = ?",
+		viewAdult, userID,
+	)
+	return err
+}
+
 func (r *UserRepo) CheckPassword(userID int, password string) (bool, error) {
 	var dbPassword string
 	err := r.DB.QueryRow(
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Уровни видимости работы. Владелец и принявшие приглашение участники
+// видят работу всегда, независимо от уровня и черновика.
+const (
+	VisibilityPublic     = "public"
+	VisibilityRegistered = "registered"
+	VisibilityUnlisted   = "unlisted"
+	VisibilityPrivate    = "private"
+)
+
+var Visibilities = []Option{
+	{VisibilityPublic, "Public"},
+	{VisibilityRegistered, "Registered Users Only"},
+	{VisibilityUnlisted, "Unlisted (By Link Only)"},
+	{VisibilityPrivate, "Private"},
+}
+
+var ErrBadVisibility = errors.New("unknown visibility level")
+
+// VisibilityLabel возвращает подпись уровня видимости или пустую строку
+func VisibilityLabel(visibility string) string {
+	return optionLabel(Visibilities, visibility)
+}
+
+func (b *Book) VisibilityLabel() string {
+	return VisibilityLabel(b.Visibility)
+}
+
+// IsListed - уровень позволяет показывать работу в выдаче, лентах и
+// профилях и оповещать о ней подписчиков
+func (b *Book) IsListed() bool {
+	return b.Visibility == VisibilityPublic || b.Visibility == VisibilityRegistered
+}
+
+// Notifiable - о работе можно оповещать подписчиков: она опубликована и
+// показывается в списках. Одно правило для публикации, расписания и правок.
+func (b *Book) Notifiable() bool {
+	return !b.Draft && b.IsListed()
+}
+
+// participantCondition отбирает работы, где пользователь владелец или
+// участник; оба параметра - id пользователя
+const participantCondition = "(b.user_id = ? OR " + coAuthoredCondition + ")"
+
+// listedCondition отбирает опубликованные работы, которые viewerID может
+// встретить в выдаче: гость - только публичные, вошедший - и работы для
+// зарегистрированных
+func listedCondition(viewerID int) string {
+	if viewerID > 0 {
+		return "(" + publishedCondition + " AND b.visibility IN ('" +
+			VisibilityPublic + "', '" + VisibilityRegistered + "'))"
+	}
+	return "(" + publishedCondition + " AND b.visibility = '" + VisibilityPublic + "')"
+}
+
+// visibleToCondition дополнительно пропускает все работы, где viewerID
+// владелец или участник, включая черновики и скрытые
+func visibleToCondition(viewerID int) (string, []interface{}) {
+	if viewerID <= 0 {
+		return listedCondition(viewerID), nil
+	}
+	return "(" + listedCondition(viewerID) + " OR " + participantCondition + ")",
+		[]interface{}{viewerID, viewerID}
+}
+
+// accessibleCondition отбирает работы, которые viewerID может открыть по
+// ссылке: в отличие от visibleToCondition пропускает и работы вне списков
+func accessibleCondition(viewerID int) (string, []interface{}) {
+	levels := "'" + VisibilityPublic + "', '" + VisibilityUnlisted + "'"
+	if viewerID <= 0 {
+		return "(" + publishedCondition + " AND b.visibility IN (" + levels + "))", nil
+	}
+	levels += ", '" + VisibilityRegistered + "'"
+	return "((" + publishedCondition + " AND b.visibility IN (" + levels + ")) OR " + participantCondition + ")",
+		[]interface{}{viewerID, viewerID}
+}
+
+// CanView сообщает, может ли viewerID открыть работу - то же правило, что
+// и accessibleCondition
+func (r *BookRepo) CanView(book *Book, viewerID int) (bool, error) {
+	if !book.Draft {
+		switch book.Visibility {
+		case VisibilityPublic, VisibilityUnlisted:
+			return true, nil
+		case VisibilityRegistered:
+			if viewerID > 0 {
+				return true, nil
+			}
+		}
+	}
+	if viewerID <= 0 {
+		return false, nil
+	}
+	if book.UserID == viewerID {
+		return true, nil
+	}
+	var participant bool
+	err := r.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM book_authors WHERE book_id = ? AND user_id = ? AND status = ?)
+	`, book.ID, viewerID, InviteAccepted).Scan(&participant)
+	return participant, err
+}
+
+// SetVisibility меняет уровень видимости работы
+func (r *BookRepo) SetVisibility(bookID int, visibility string) error {
+	if VisibilityLabel(visibility) == "" {
+		return ErrBadVisibility
+	}
+	_, err := r.DB.Exec("UPDATE books SET visibility = ? WHERE id = ?", visibility, bookID)
+	return err
+}
+
+// GetByFilePath находит работы по пути к их файлу. Новые загрузки получают
+// уникальный путь, но у работ, загруженных раньше, путь мог совпасть, когда
+// автор повторно загружал файл с тем же именем. Пути, сохраненные под
+// Windows, записаны с обратной косой чертой.
+func (r *BookRepo) GetByFilePath(path string) ([]*Book, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+bookColumns+`
+		FROM books b
+		JOIN users u ON b.user_id = u.id
+		WHERE b.file_path IN (?, ?)
+		ORDER BY b.id
+	`, path, strings.ReplaceAll(path, "/", `\`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []*Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// Статусы завершенности работы
+const (
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+	StatusOnHiatus   = "on_hiatus"
+	StatusAbandoned  = "abandoned"
+)
+
+var ErrBadStatus = errors.New("unknown work status")
+
+var WorkStatuses = []Option{
+	{StatusInProgress, "In Progress"},
+	{StatusCompleted, "Complete"},
+	{StatusOnHiatus, "On Hiatus"},
+	{StatusAbandoned, "Abandoned"},
+}
+
+// WorkStatusLabel возвращает подпись статуса или пустую строку
+func WorkStatusLabel(status string) string {
+	return optionLabel(WorkStatuses, status)
+}
+
+// Длина работы по числу слов
+const (
+	LengthFlash  = "flash"
+	LengthShort  = "short"
+	LengthMedium = "medium"
+	LengthLong   = "long"
+	LengthEpic   = "epic"
+)
+
+var LengthBuckets = []Option{
+	{LengthFlash, "Under 1K words"},
+	{LengthShort, "1K-10K words"},
+	{LengthMedium, "10K-50K words"},
+	{LengthLong, "50K-100K words"},
+	{LengthEpic, "Over 100K words"},
+}
+
+// LengthBucketLabel возвращает подпись диапазона длины или пустую строку
+func LengthBucketLabel(bucket string) string {
+	return optionLabel(LengthBuckets, bucket)
+}
+
+// lengthBucketExpr вычисляет диапазон длины в SQL; границы совпадают с LengthBucket
+const lengthBucketExpr = `CASE
+		WHEN b.word_count < 1000 THEN 'flash'
+		WHEN b.word_count < 10000 THEN 'short'
+		WHEN b.word_count < 50000 THEN 'medium'
+		WHEN b.word_count < 100000 THEN 'long'
+		ELSE 'epic' END`
+
+// LengthBucket возвращает диапазон длины для числа слов
+func LengthBucket(words int) string {
+	switch {
+	case words < 1000:
+		return LengthFlash
+	case words < 10000:
+		return LengthShort
+	case words < 50000:
+		return LengthMedium
+	case words < 100000:
+		return LengthLong
+	}
+	return LengthEpic
+}
+
+func (b *Book) StatusLabel() string {
+	if label := WorkStatusLabel(b.Status); label != "" {
+		return label
+	}
+	return WorkStatusLabel(StatusInProgress)
+}
+
+func (b *Book) IsCompleted() bool {
+	return b.Status == StatusCompleted
+}
+
+// readingSpeed - средняя скорость чтения художественного текста, слов в минуту
+const readingSpeed = 200
+
+// ReadingMinutes оценивает время чтения работы, округляя вверх
+func (b *Book) ReadingMinutes() int {
+	return (b.WordCount + readingSpeed - 1) / readingSpeed
+}
+
+// DialoguePercent - доля прямой речи в процентах
+func (b *Book) DialoguePercent() int {
+	return int(b.DialogueRatio*100 + 0.5)
+}
+
+// UpdateTextStats сохраняет посчитанную по тексту статистику книги book.ID
+func (r *BookRepo) UpdateTextStats(book *Book) error {
+	_, err := r.DB.Exec(`
+		UPDATE books
+		SET word_count = ?, chapter_count = ?, char_count = ?, dialogue_ratio = ?, avg_sentence_length = ?
+		WHERE id = ?
+	`, book.WordCount, book.ChapterCount, book.CharCount, book.DialogueRatio, book.AvgSentenceLength, book.ID)
+	return err
+}
+
+// WithoutTextStats возвращает id и пути файлов книг, для которых статистика
+// еще не посчитана (загруженных до ее появления)
+func (r *BookRepo) WithoutTextStats() (map[int]string, error) {
+	rows, err := r.DB.Query("SELECT id, file_path FROM books WHERE char_count = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := map[int]string{}
+	for rows.Next() {
+		var id int
+		var filePath string
+		if err := rows.Scan(&id, &filePath); err != nil {
+			return nil, err
+		}
+		files[id] = filePath
+	}
+	return files, rows.Err()
+}
+
+// inCondition строит "expr IN (?, ?, ...)" для списка значений
+func inCondition(expr string, values []string, args []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = "?"
+		args = append(args, value)
+	}
+	return expr + " IN (" + strings.Join(placeholders, ",") + ")", args
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"fmt"
+
+	"sob/pkg/events"
+	"sob/pkg/models"
+
+	"go.uber.org/zap"
+)
+
+// KudosMilestones - значения счетчика кудосов, о которых сообщаем автору
+var KudosMilestones = []int{1, 10, 25, 50, 100, 250, 500, 1000}
+
+// Service - единая точка создания уведомлений. Обработчики сообщают, что
+// произошло, а сервис решает, кому и как это доставить. Ошибки доставки
+// только логируются: уведомление не должно ломать основное действие.
+type Service struct {
+	Repo       *models.NotificationRepo
+	FollowRepo *models.FollowRepo
+	Logger     *zap.SugaredLogger
+}
+
+func NewService(repo *models.NotificationRepo, followRepo *models.FollowRepo, logger *zap.SugaredLogger) *Service {
+	return &Service{Repo: repo, FollowRepo: followRepo, Logger: logger}
+}
+
+// Notify отправляет уведомление получателям. Автор действия сам себе
+// уведомлений не получает, повторы получателей отбрасываются.
+func (s *Service) Notify(n models.Notification, recipients ...int) {
+	seen := make(map[int]bool, len(recipients))
+	var userIDs []int
+	for _, id := range recipients {
+		if id == 0 || id == n.ActorID || seen[id] {
+			continue
+		}
+		seen[id] = true
+		userIDs = append(userIDs, id)
+	}
+
+	if err := s.Repo.CreateForUsers(n, userIDs); err != nil {
+		s.Logger.Error("Create notifications error:", err)
+	}
+}
+
+func bookLink(bookID int) string {
+	return fmt.Sprintf("/books/%d", bookID)
+}
+
+// BookRated сообщает автору работы о новой оценке
+func (s *Service) BookRated(book *models.Book, actorID, rating int) {
+	s.Notify(models.Notification{
+		Type:    models.NotifyRating,
+		ActorID: actorID,
+		BookID:  book.ID,
+		Message: fmt.Sprintf("rated «%s» %d/5", book.Title, rating),
+		Link:    bookLink(book.ID),
+	}, book.UserID)
+}
+
+// CommentAdded сообщает автору работы о новом комментарии, а автору
+// родительского комментария - об ответе
+func (s *Service) CommentAdded(book *models.Book, comment *models.Comment, parent *models.Comment) {
+	link := fmt.Sprintf("/books/%d#comment-%d", book.ID, comment.ID)
+
+	if parent != nil {
+		s.Notify(models.Notification{
+			Type:    models.NotifyCommentReply,
+			ActorID: comment.UserID,
+			BookID:  book.ID,
+			Message: fmt.Sprintf("replied to your comment on «%s»", book.Title),
+			Link:    link,
+		}, parent.UserID)
+
+		// Автор работы уже получил уведомление как автор родительского комментария
+		if parent.UserID == book.UserID {
+			return
+		}
+	}
+
+	s.Notify(models.Notification{
+		Type:    models.NotifyNewComment,
+		ActorID: comment.UserID,
+		BookID:  book.ID,
+		Message: fmt.Sprintf("commented on «%s»", book.Title),
+		Link:    link,
+	}, book.UserID)
+}
+
+// KudosReceived сообщает автору, когда счетчик кудосов достигает очередной отметки
+func (s *Service) KudosReceived(book *models.Book, count int) {
+	for _, milestone := range KudosMilestones {
+		if count != milestone {
+			continue
+		}
+		s.Notify(models.Notification{
+			Type:    models.NotifyKudosMilestone,
+			BookID:  book.ID,
+			Message: fmt.Sprintf("«%s» received %d kudos", book.Title, count),
+			Link:    bookLink(book.ID),
+		}, book.UserID)
+		return
+	}
+}
+
+// AuthorInvited сообщает пользователю о приглашении участвовать в работе
+func (s *Service) AuthorInvited(book *models.Book, ownerID, userID int, role string) {
+	s.Notify(models.Notification{
+		Type:    models.NotifyAuthorInvite,
+		ActorID: ownerID,
+		BookID:  book.ID,
+		Message: fmt.Sprintf("invited you to «%s» as %s", book.Title, models.AuthorRoleLabel(role)),
+		Link:    "/profile?tab=invitations",
+	}, userID)
+}
+
+// SavedSearchMatched сообщает пользователю о новых работах в выдаче его
+// сохраненного поиска
+func (s *Service) SavedSearchMatched(search *models.SavedSearch, count int) {
+	message := fmt.Sprintf("%d new works match your saved search «%s»", count, search.Name)
+	if count == 1 {
+		message = fmt.Sprintf("a new work matches your saved search «%s»", search.Name)
+	}
+	s.Notify(models.Notification{
+		Type:    models.NotifySavedSearch,
+		Message: message,
+		Link:    fmt.Sprintf("/saved-searches/%d", search.ID),
+	}, search.UserID)
+}
+
+// HandleEvent - подписчик шины событий: раздает уведомления о новых работах
+// подписчикам автора и об обновлениях - подписчикам работы
+func (s *Service) HandleEvent(event events.Event) {
+	var recipients []int
+	var err error
+	n := models.Notification{
+		ActorID: event.ActorID,
+		BookID:  event.BookID,
+		Link:    bookLink(event.BookID),
+	}
+
+	switch event.Type {
+	case events.BookPublished:
+		n.Type = models.NotifyNewWork
+		n.Message = fmt.Sprintf("published a new work «%s»", event.BookTitle)
+		recipients, err = s.FollowRepo.FollowerIDs(event.ActorID)
+	case events.BookUpdated:
+		n.Type = models.NotifyWorkUpdated
+		n.Message = fmt.Sprintf("updated «%s»", event.BookTitle)
+		recipients, err = s.FollowRepo.SubscriberIDs(event.BookID)
+	default:
+		return
+	}
+
+	if err != nil {
+		s.Logger.Error("Get notification recipients error:", err)
+		return
+	}
+	s.Notify(n, recipients...)
+}
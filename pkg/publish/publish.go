@@ -0,0 +1,56 @@
+package publish
+
+import (
+	"time"
+
+	"sob/pkg/events"
+	"sob/pkg/models"
+
+	"go.uber.org/zap"
+)
+
+// Scheduler периодически публикует черновики, время публикации которых
+// наступило, и оповещает подписчиков авторов так же, как при ручной
+// публикации.
+type Scheduler struct {
+	Books    *models.BookRepo
+	Events   *events.Bus
+	Logger   *zap.SugaredLogger
+	Interval time.Duration
+}
+
+// Run публикует работы по расписанию до закрытия stop
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.RunOnce()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RunOnce публикует все работы, которые пора опубликовать
+func (s *Scheduler) RunOnce() {
+	books, err := s.Books.PublishDue(time.Now())
+	if err != nil {
+		s.Logger.Error("Publish scheduled works error:", err)
+	}
+	for _, book := range books {
+		s.Logger.Infow("Scheduled work published", "book_id", book.ID)
+		// О скрытых и приватных работах подписчики не узнают
+		if !book.Notifiable() {
+			continue
+		}
+		s.Events.Publish(events.Event{
+			Type:      events.BookPublished,
+			ActorID:   book.UserID,
+			BookID:    book.ID,
+			BookTitle: book.Title,
+		})
+	}
+}
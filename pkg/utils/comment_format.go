@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	commentCodeRe   = regexp.MustCompile("`([^`\n]+)`")
+	commentBoldRe   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	commentItalicRe = regexp.MustCompile(`\*([^*\n]+)\*`)
+	commentStrikeRe = regexp.MustCompile(`~~([^~\n]+)~~`)
+	commentLinkRe   = regexp.MustCompile(`https?://[^\s<]+[^\s<.,;:!?)"'\]]`)
+)
+
+// RenderCommentMarkdown превращает упрощенную разметку комментария в HTML.
+// Текст сначала экранируется целиком, поэтому пользовательский HTML не
+// проходит; поддерживаются **жирный**, *курсив*, ~~зачеркнутый~~, `код`,
+// цитаты через "> " и ссылки http(s).
+func RenderCommentMarkdown(text string) template.HTML {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n")
+
+	var result strings.Builder
+	var quote []string
+
+	flushQuote := func() {
+		if len(quote) > 0 {
+			result.WriteString("<blockquote>" + strings.Join(quote, "<br>") + "</blockquote>")
+			quote = nil
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			quote = append(quote, formatCommentLine(strings.TrimSpace(strings.TrimPrefix(line, ">"))))
+			continue
+		}
+		flushQuote()
+
+		result.WriteString(formatCommentLine(line))
+		if i < len(lines)-1 {
+			result.WriteString("<br>")
+		}
+	}
+	flushQuote()
+
+	return template.HTML(result.String())
+}
+
+func formatCommentLine(line string) string {
+	line = html.EscapeString(line)
+
+	// Код обрабатываем первым, чтобы внутри него не срабатывала остальная разметка
+	var codes []string
+	line = commentCodeRe.ReplaceAllStringFunc(line, func(m string) string {
+		codes = append(codes, "<code>"+m[1:len(m)-1]+"</code>")
+		return "\x00"
+	})
+
+	line = commentBoldRe.ReplaceAllString(line, "<strong>$1</strong>")
+	line = commentItalicRe.ReplaceAllString(line, "<em>$1</em>")
+	line = commentStrikeRe.ReplaceAllString(line, "<del>$1</del>")
+	line = commentLinkRe.ReplaceAllStringFunc(line, func(link string) string {
+		return `<a href="` + link + `" rel="nofollow noopener" target="_blank">` + link + `</a>`
+	})
+
+	for _, code := range codes {
+		line = strings.Replace(line, "\x00", code, 1)
+	}
+	return line
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Виды фрагментов сравнения
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// maxDiffEdits ограничивает поиск кратчайшей правки: если тексты
+// различаются сильнее, отличающийся кусок показывается как замена целиком
+const maxDiffEdits = 1000
+
+// DiffOp - фрагмент сравнения двух текстов. Skipped отмечает совпадающий
+// фрагмент, середина которого свернута.
+type DiffOp struct {
+	Kind    string
+	Text    string
+	Skipped bool
+}
+
+func (op DiffOp) IsEqual() bool  { return op.Kind == DiffEqual }
+func (op DiffOp) IsInsert() bool { return op.Kind == DiffInsert }
+func (op DiffOp) IsDelete() bool { return op.Kind == DiffDelete }
+
+// WordDiff сравнивает тексты с точностью до слова. Сначала сравниваются
+// строки, а затем слова внутри измененных строк - так длинные тексты
+// сравниваются быстро, а правки внутри абзаца видны по словам.
+func WordDiff(a, b string) []DiffOp {
+	var ops []DiffOp
+	var deleted, inserted strings.Builder
+	flush := func() {
+		if deleted.Len() > 0 || inserted.Len() > 0 {
+			ops = appendOps(ops, diffTokens(splitWords(deleted.String()), splitWords(inserted.String())))
+			deleted.Reset()
+			inserted.Reset()
+		}
+	}
+
+	for _, op := range diffTokens(splitLines(a), splitLines(b)) {
+		switch op.Kind {
+		case DiffDelete:
+			deleted.WriteString(op.Text)
+		case DiffInsert:
+			inserted.WriteString(op.Text)
+		default:
+			flush()
+			ops = appendOps(ops, []DiffOp{op})
+		}
+	}
+	flush()
+	return ops
+}
+
+// DiffStats считает вставленные и удаленные слова
+func DiffStats(ops []DiffOp) (inserted, deleted int) {
+	for _, op := range ops {
+		switch op.Kind {
+		case DiffInsert:
+			inserted += len(strings.Fields(op.Text))
+		case DiffDelete:
+			deleted += len(strings.Fields(op.Text))
+		}
+	}
+	return inserted, deleted
+}
+
+// CollapseDiff сворачивает середину длинных совпадающих фрагментов,
+// оставляя context символов вокруг правок
+func CollapseDiff(ops []DiffOp, context int) []DiffOp {
+	collapsed := make([]DiffOp, 0, len(ops))
+	for i, op := range ops {
+		if op.Kind != DiffEqual || utf8.RuneCountInString(op.Text) <= 2*context {
+			collapsed = append(collapsed, op)
+			continue
+		}
+		runes := []rune(op.Text)
+		var head, tail string
+		if i > 0 {
+			head = string(runes[:context])
+		}
+		if i < len(ops)-1 {
+			tail = string(runes[len(runes)-context:])
+		}
+		if head != "" {
+			collapsed = append(collapsed, DiffOp{Kind: DiffEqual, Text: head})
+		}
+		collapsed = append(collapsed, DiffOp{Kind: DiffEqual, Skipped: true})
+		if tail != "" {
+			collapsed = append(collapsed, DiffOp{Kind: DiffEqual, Text: tail})
+		}
+	}
+	return collapsed
+}
+
+// splitLines делит текст на строки вместе с переводами строк
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.SplitAfter(s, "\n")
+}
+
+// splitWords делит текст на слова, пробельные промежутки и отдельные
+// знаки препинания, так что склейка токенов дает исходный текст
+func splitWords(s string) []string {
+	var tokens []string
+	start := 0
+	kind := 0
+	for i, r := range s {
+		k := tokenKind(r)
+		if i > start && (k != kind || k == 3) {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		kind = k
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func tokenKind(r rune) int {
+	switch {
+	case isWordRune(r):
+		return 1
+	case unicode.IsSpace(r):
+		return 2
+	}
+	return 3
+}
+
+// diffTokens отбрасывает общие начало и конец и сравнивает остаток
+// алгоритмом Майерса
+func diffTokens(a, b []string) []DiffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []DiffOp
+	ops = appendOps(ops, tokenOps(DiffEqual, a[:prefix]))
+	ops = appendOps(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]))
+	ops = appendOps(ops, tokenOps(DiffEqual, a[len(a)-suffix:]))
+	return ops
+}
+
+// myersDiff находит кратчайшую последовательность правок (Myers, 1986).
+// На каждом шаге d сохраняется только диагонали -d..d, поэтому память
+// растет как квадрат числа правок, а не произведение длин.
+func myersDiff(a, b []string) []DiffOp {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return appendOps(tokenOps(DiffDelete, a), tokenOps(DiffInsert, b))
+	}
+
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(trace, a, b)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	// Слишком много правок - показываем отличающийся кусок заменой целиком
+	return appendOps(tokenOps(DiffDelete, a), tokenOps(DiffInsert, b))
+}
+
+// backtrack восстанавливает правки по сохраненным диагоналям, от конца
+// текстов к началу
+func backtrack(trace [][]int, a, b []string) []DiffOp {
+	at := func(d, k int) int { return trace[d][k+d] }
+
+	var reversed []DiffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(d-1, k-1) < at(d-1, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(d-1, prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, DiffOp{Kind: DiffEqual, Text: a[x]})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, DiffOp{Kind: DiffInsert, Text: b[y]})
+		} else {
+			x--
+			reversed = append(reversed, DiffOp{Kind: DiffDelete, Text: a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		reversed = append(reversed, DiffOp{Kind: DiffEqual, Text: a[x]})
+	}
+
+	// Склеиваем токены одного вида через Builder: прибавление к строке по
+	// токену было бы квадратичным на длинных совпадениях
+	var ops []DiffOp
+	var text strings.Builder
+	for i := len(reversed) - 1; i >= 0; i-- {
+		text.WriteString(reversed[i].Text)
+		if i == 0 || reversed[i-1].Kind != reversed[i].Kind {
+			ops = append(ops, DiffOp{Kind: reversed[i].Kind, Text: text.String()})
+			text.Reset()
+		}
+	}
+	return ops
+}
+
+func tokenOps(kind string, tokens []string) []DiffOp {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return []DiffOp{{Kind: kind, Text: strings.Join(tokens, "")}}
+}
+
+// appendOps добавляет фрагменты, склеивая соседние фрагменты одного вида
+func appendOps(ops []DiffOp, more []DiffOp) []DiffOp {
+	for _, op := range more {
+		if op.Text == "" {
+			continue
+		}
+		if last := len(ops) - 1; last >= 0 && ops[last].Kind == op.Kind {
+			ops[last].Text += op.Text
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
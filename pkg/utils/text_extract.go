@@ -0,0 +1,395 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// ErrUnsupportedFormat - из файла этого формата текст извлечь нельзя
+var ErrUnsupportedFormat = errors.New("unsupported book format")
+
+// maxExtractedFile - из архивов (docx, epub) не читаем файлы больше этого размера
+const maxExtractedFile = 64 << 20
+
+// ExtractPlainText извлекает из файла книги текст без разметки - для подсчета
+// слов, глав и прочей статистики. Для pdf и doc извлечение приблизительное.
+func ExtractPlainText(filePath string) (string, error) {
+	ext := strings.ToLower(getFileExtension(filePath))
+	switch ext {
+	case ".docx":
+		return extractDocx(filePath)
+	case ".epub":
+		return extractEpub(filePath)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	switch ext {
+	case ".txt":
+		return string(content), nil
+	case ".md", ".markdown":
+		return htmlToText(parseMarkdown(content)), nil
+	case ".html", ".htm", ".xhtml":
+		return htmlToText(string(content)), nil
+	case ".rtf":
+		return rtfToText(string(content)), nil
+	case ".pdf":
+		return pdfToText(content), nil
+	case ".doc":
+		return docToText(content), nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// htmlToText убирает разметку и раскрывает HTML-сущности
+func htmlToText(s string) string {
+	s = removeTag(s, "head")
+	return html.UnescapeString(parseHTML([]byte(s)))
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxExtractedFile))
+}
+
+// extractDocx читает word/document.xml: текст из w:t, абзацы из w:p
+func extractDocx(filePath string) (string, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return "", err
+		}
+
+		var text strings.Builder
+		inText := false
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					text.WriteString("\t")
+				case "br":
+					text.WriteString("\n")
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					text.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					text.Write(t)
+				}
+			}
+		}
+		return text.String(), nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// extractEpub собирает текст документов книги в порядке spine из OPF;
+// если OPF не найден, берет все xhtml-файлы архива по имени
+func extractEpub(filePath string) (string, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	docs := epubSpine(files)
+	if len(docs) == 0 {
+		for name := range files {
+			ext := strings.ToLower(path.Ext(name))
+			if ext == ".xhtml" || ext == ".html" || ext == ".htm" {
+				docs = append(docs, name)
+			}
+		}
+		sort.Strings(docs)
+	}
+
+	var parts []string
+	for _, name := range docs {
+		f, ok := files[name]
+		if !ok {
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, htmlToText(string(data)))
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+func epubSpine(files map[string]*zip.File) []string {
+	container, ok := files["META-INF/container.xml"]
+	if !ok {
+		return nil
+	}
+	data, err := readZipFile(container)
+	if err != nil {
+		return nil
+	}
+	var c struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if xml.Unmarshal(data, &c) != nil || len(c.Rootfiles) == 0 {
+		return nil
+	}
+
+	opfPath := c.Rootfiles[0].FullPath
+	opfFile, ok := files[opfPath]
+	if !ok {
+		return nil
+	}
+	data, err = readZipFile(opfFile)
+	if err != nil {
+		return nil
+	}
+	var opf struct {
+		Items []struct {
+			ID   string `xml:"id,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+		Refs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"spine>itemref"`
+	}
+	if xml.Unmarshal(data, &opf) != nil {
+		return nil
+	}
+
+	hrefs := make(map[string]string, len(opf.Items))
+	for _, item := range opf.Items {
+		hrefs[item.ID] = item.Href
+	}
+	base := path.Dir(opfPath)
+	var docs []string
+	for _, ref := range opf.Refs {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		docs = append(docs, path.Join(base, href))
+	}
+	return docs
+}
+
+var (
+	rtfGroupToSkip = regexp.MustCompile(`\{\\(?:\*|fonttbl|colortbl|stylesheet|info|pict)[^{}]*(?:\{[^{}]*\}[^{}]*)*\}`)
+	rtfHex         = regexp.MustCompile(`\\'([0-9a-fA-F]{2})`)
+	rtfUnicode     = regexp.MustCompile(`\\u(-?\d+) ?\??`)
+	rtfParagraph   = regexp.MustCompile(`\\(?:par|line)\b ?`)
+	rtfControl     = regexp.MustCompile(`\\[a-zA-Z]+-?\d* ?`)
+)
+
+// rtfToText убирает управляющие слова RTF; служебные группы (шрифты,
+// стили, картинки) выбрасываются целиком
+func rtfToText(s string) string {
+	s = rtfGroupToSkip.ReplaceAllString(s, "")
+	s = rtfUnicode.ReplaceAllStringFunc(s, func(m string) string {
+		n := rtfUnicode.FindStringSubmatch(m)[1]
+		code := 0
+		neg := strings.HasPrefix(n, "-")
+		for _, d := range strings.TrimPrefix(n, "-") {
+			code = code*10 + int(d-'0')
+		}
+		if neg {
+			code += 65536
+		}
+		return string(rune(code))
+	})
+	s = rtfHex.ReplaceAllStringFunc(s, func(m string) string {
+		var b byte
+		for _, c := range strings.ToLower(m[2:]) {
+			b <<= 4
+			if c >= 'a' {
+				b |= byte(c-'a') + 10
+			} else {
+				b |= byte(c - '0')
+			}
+		}
+		return string(rune(b))
+	})
+	s = rtfParagraph.ReplaceAllString(s, "\n")
+	s = rtfControl.ReplaceAllString(s, "")
+	s = strings.NewReplacer(`\{`, "{", `\}`, "}", `\\`, `\`, "{", "", "}", "").Replace(s)
+	return s
+}
+
+var (
+	pdfStream  = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n(.*?)\r?\nendstream`)
+	pdfTextOps = regexp.MustCompile(`(?s)\((?:\\.|[^\\)])*\)|\bT[dD*]\b|'|"|\bET\b`)
+)
+
+// pdfToText извлекает строки из операторов вывода текста в потоках PDF.
+// Поддерживаются только FlateDecode и несжатые потоки с простыми шрифтами -
+// для подсчета слов этого достаточно.
+func pdfToText(content []byte) string {
+	var text strings.Builder
+	for _, m := range pdfStream.FindAllSubmatch(content, -1) {
+		dict, data := m[1], m[2]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			data, err = io.ReadAll(io.LimitReader(zr, maxExtractedFile))
+			zr.Close()
+			if err != nil && len(data) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		if !bytes.Contains(data, []byte("BT")) {
+			continue
+		}
+
+		for _, op := range pdfTextOps.FindAll(data, -1) {
+			if op[0] == '(' {
+				text.WriteString(unescapePDFString(op[1 : len(op)-1]))
+			} else {
+				text.WriteString("\n")
+			}
+		}
+	}
+	return text.String()
+}
+
+func unescapePDFString(s []byte) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b', 'f':
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			code := 0
+			for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+				code = code*8 + int(s[i]-'0')
+				i++
+			}
+			i--
+			out = append(out, byte(code))
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// docToText достает текст из старого бинарного формата Word эвристически:
+// собирает длинные последовательности печатных символов в UTF-16LE и в
+// однобайтовой кодировке и берет тот вариант, в котором больше слов
+func docToText(content []byte) string {
+	const minRun = 8
+
+	var wide strings.Builder
+	var run []rune
+	flush := func(b *strings.Builder) {
+		if len(run) >= minRun {
+			b.WriteString(string(run))
+			b.WriteString("\n")
+		}
+		run = run[:0]
+	}
+
+	units := make([]uint16, len(content)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(content[i*2:])
+	}
+	for _, r := range utf16.Decode(units) {
+		if isDocTextRune(r) {
+			run = append(run, r)
+		} else {
+			flush(&wide)
+		}
+	}
+	flush(&wide)
+
+	var narrow strings.Builder
+	for _, b := range content {
+		if r := rune(b); r < 0x80 && isDocTextRune(r) {
+			run = append(run, r)
+		} else {
+			flush(&narrow)
+		}
+	}
+	flush(&narrow)
+
+	text := wide.String()
+	if len(strings.Fields(narrow.String())) > len(strings.Fields(text)) {
+		text = narrow.String()
+	}
+	// Word разделяет абзацы символом \r
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+func isDocTextRune(r rune) bool {
+	return r == '\r' || r == '\n' || r == '\t' ||
+		(unicode.IsPrint(r) && !unicode.Is(unicode.Han, r) && r < 0x2000) ||
+		(r >= 0x2010 && r <= 0x2027)
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TextStats - статистика по извлеченному тексту работы
+type TextStats struct {
+	Words    int
+	Chapters int
+	// Characters - число символов с пробелами
+	Characters int
+	// DialogueRatio - доля символов текста, приходящаяся на прямую речь (0..1)
+	DialogueRatio float64
+	// AvgSentenceLength - среднее число слов в предложении
+	AvgSentenceLength float64
+}
+
+// chapterHeading - строка, с которой начинается глава: "Глава 1",
+// "Chapter IV: Title", "Пролог", "Epilogue" и т.п.
+var chapterHeading = regexp.MustCompile(`(?i)^(?:глава|chapter|пролог|prologue|эпилог|epilogue)(?:\s+[\p{L}\d]+)?\s*(?:[.:!—–-].*)?$`)
+
+// maxHeadingLength - более длинные строки считаются текстом, а не заголовком
+const maxHeadingLength = 100
+
+func isChapterHeading(line string) bool {
+	line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#*"))
+	if line == "" || len([]rune(line)) > maxHeadingLength {
+		return false
+	}
+	return chapterHeading.MatchString(line)
+}
+
+// AnalyzeText считает статистику текста. Словом считается последовательность
+// символов между пробелами, содержащая хотя бы одну букву или цифру. Если
+// заголовков глав не нашлось, непустой текст считается одной главой.
+func AnalyzeText(text string) TextStats {
+	var stats TextStats
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	stats.Characters = utf8.RuneCountInString(text)
+	for _, field := range strings.Fields(text) {
+		if strings.IndexFunc(field, isWordRune) >= 0 {
+			stats.Words++
+		}
+	}
+	if stats.Words == 0 {
+		return stats
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if isChapterHeading(line) {
+			stats.Chapters++
+		}
+	}
+	if stats.Chapters == 0 {
+		stats.Chapters = 1
+	}
+
+	stats.DialogueRatio = dialogueRatio(text)
+	stats.AvgSentenceLength = float64(stats.Words) / float64(countSentences(text))
+	return stats
+}
+
+// dialogueRatio считает долю непробельных символов в прямой речи: в кавычках
+// («», “”, "") и в абзацах, начинающихся с тире, как принято в русской прозе.
+// Кавычки не переходят через абзац, так что незакрытая кавычка не захватывает
+// остаток текста.
+func dialogueRatio(text string) float64 {
+	var total, dialogue int
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		dashLine := strings.HasPrefix(trimmed, "—") || strings.HasPrefix(trimmed, "–") || strings.HasPrefix(trimmed, "- ")
+
+		inQuote := false
+		for _, r := range trimmed {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			total++
+			switch r {
+			case '«', '“':
+				inQuote = true
+			case '»', '”':
+				inQuote = false
+			case '"':
+				inQuote = !inQuote
+			}
+			if dashLine || inQuote || r == '»' || r == '”' || r == '"' {
+				dialogue++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(dialogue) / float64(total)
+}
+
+// sentenceEnd - конец предложения: точки, восклицательные и вопросительные
+// знаки или многоточие, за которыми идет пробел или конец текста
+var sentenceEnd = regexp.MustCompile(`[.!?…]+["»”)]*(?:\s|$)`)
+
+func countSentences(text string) int {
+	count := 0
+	for _, paragraph := range strings.Split(text, "\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if strings.IndexFunc(paragraph, isWordRune) < 0 {
+			continue
+		}
+		ends := len(sentenceEnd.FindAllStringIndex(paragraph, -1))
+		// Абзац без знака в конце (заголовок, реплика) - тоже предложение
+		if !strings.ContainsRune(".!?…", lastRune(strings.TrimRight(paragraph, `"»”)`))) {
+			ends++
+		}
+		count += ends
+	}
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// AnalyzeFile извлекает текст из файла книги и считает его статистику
+func AnalyzeFile(filePath string) (TextStats, error) {
+	text, err := ExtractPlainText(filePath)
+	if err != nil {
+		return TextStats{}, err
+	}
+	return AnalyzeText(text), nil
+}
+
+// FormatNumber разбивает число на группы разрядов: 12345 -> "12,345"
+func FormatNumber(n int) string {
+	if n < 0 {
+		return "-" + FormatNumber(-n)
+	}
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}